@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ApplyPatchDefinition = ToolDefinition{
+	Name: "apply_patch",
+	Description: `Apply a unified diff to one or more files.
+
+The 'patch' is in the format of 'diff -u' or 'git diff': each file starts with '--- a/<path>' and '+++ b/<path>' lines followed by '@@' hunks. Use '--- /dev/null' to create a file and '+++ /dev/null' to delete one. Renames use the 'git diff' lines 'rename from <path>' and 'rename to <path>'.
+
+Hunks are located by their context and removed lines, so the line numbers in the '@@' headers may be off, but the line counts must match the hunk. Include a few unchanged lines around each change so the hunk matches only one place.
+
+If any hunk doesn't apply, the whole patch is rejected and no file is changed.
+`,
+	InputSchema: ApplyPatchInputSchema,
+	Function:    ApplyPatch,
+	Mutating:    true,
+}
+
+type ApplyPatchInput struct {
+	Patch string `json:"patch" jsonschema_description:"The unified diff to apply"`
+}
+
+var ApplyPatchInputSchema = GenerateSchema[ApplyPatchInput]()
+
+// filePatch is the part of a patch that changes one file. oldPath is empty
+// when the file is created and newPath is empty when it is deleted.
+type filePatch struct {
+	oldPath    string
+	newPath    string
+	hunks      []patchHunk
+	headerSeen bool
+}
+
+// patchHunk is one '@@' hunk. oldStart is the 1-indexed line the hunk starts
+// at in the original file, or -1 if the header has no line numbers. The lines
+// keep their line endings.
+type patchHunk struct {
+	oldStart int
+	oldLines []string
+	newLines []string
+	added    int
+	removed  int
+}
+
+// patchChange is a write or removal that applying a patch will make.
+type patchChange struct {
+	path    string
+	content string
+	remove  bool
+}
+
+func ApplyPatch(ctx context.Context, input json.RawMessage) (string, error) {
+	applyPatchInput := ApplyPatchInput{}
+	err := json.Unmarshal(input, &applyPatchInput)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(applyPatchInput.Patch) == "" {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+
+	files, err := parsePatch(applyPatchInput.Patch)
+	if err != nil {
+		return "", err
+	}
+
+	changes, summary, err := planPatch(files)
+	if err != nil {
+		return "", fmt.Errorf("%w; the patch was not applied", err)
+	}
+
+	// keep the files as they were before the patch, so a failed write doesn't leave it half applied
+	snapshots := map[string]fileSnapshot{}
+	for _, change := range changes {
+		if _, ok := snapshots[change.path]; ok {
+			continue
+		}
+		snapshots[change.path], err = readSnapshot(change.path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w; the patch was not applied", workspace.Rel(change.path), err)
+		}
+	}
+
+	for i, change := range changes {
+		if change.remove {
+			err = workspace.RemoveFile(change.path)
+		} else {
+			err = workspace.MkdirAll(filepath.Dir(change.path))
+			if err == nil {
+				err = workspace.WriteFile(change.path, []byte(change.content))
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to update %s: %w", workspace.Rel(change.path), err)
+			for _, done := range changes[:i+1] {
+				restoreErr := snapshots[done.path].restore(done.path)
+				if restoreErr != nil {
+					return "", fmt.Errorf("%w; failed to restore %s: %v", err, workspace.Rel(done.path), restoreErr)
+				}
+			}
+			return "", fmt.Errorf("%w; the patch was not applied", err)
+		}
+	}
+
+	return fmt.Sprintf("Applied patch to %d file(s):\n%s", len(summary), strings.Join(summary, "\n")), nil
+}
+
+// planPatch checks that every file patch applies and works out the changes to
+// make, without touching the disk. It returns one summary line per file.
+func planPatch(files []*filePatch) ([]patchChange, []string, error) {
+	changes := []patchChange{}
+	summary := []string{}
+	// pending holds the content the earlier file patches leave a path with,
+	// or nil for paths they remove
+	pending := map[string]*string{}
+
+	read := func(path string) (string, bool, error) {
+		if content, ok := pending[path]; ok {
+			if content == nil {
+				return "", false, nil
+			}
+			return *content, true, nil
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return string(content), true, nil
+	}
+
+	for _, file := range files {
+		var oldPath, newPath string
+		var err error
+		if file.oldPath != "" {
+			oldPath, err = workspace.Resolve(file.oldPath)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if file.newPath != "" {
+			newPath, err = workspace.Resolve(file.newPath)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		oldContent := ""
+		if oldPath != "" {
+			content, exists, err := read(oldPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !exists {
+				return nil, nil, fmt.Errorf("%s: file does not exist", file.oldPath)
+			}
+			oldContent = content
+		}
+		if newPath != "" && newPath != oldPath {
+			_, exists, err := read(newPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			if exists {
+				return nil, nil, fmt.Errorf("%s: file already exists", file.newPath)
+			}
+		}
+
+		newContent, err := applyHunks(oldContent, file.hunks)
+		if err != nil {
+			name := file.oldPath
+			if name == "" {
+				name = file.newPath
+			}
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		added, removed := 0, 0
+		for _, hunk := range file.hunks {
+			added += hunk.added
+			removed += hunk.removed
+		}
+		counts := fmt.Sprintf("(+%d -%d)", added, removed)
+
+		switch {
+		case oldPath == "":
+			summary = append(summary, fmt.Sprintf("created %s %s", workspace.Rel(newPath), counts))
+		case newPath == "":
+			summary = append(summary, fmt.Sprintf("deleted %s %s", workspace.Rel(oldPath), counts))
+		case newPath != oldPath:
+			summary = append(summary, fmt.Sprintf("renamed %s -> %s %s", workspace.Rel(oldPath), workspace.Rel(newPath), counts))
+		default:
+			summary = append(summary, fmt.Sprintf("modified %s %s", workspace.Rel(oldPath), counts))
+		}
+
+		if newPath != "" {
+			changes = append(changes, patchChange{path: newPath, content: newContent})
+			pending[newPath] = &newContent
+		}
+		if oldPath != "" && oldPath != newPath {
+			changes = append(changes, patchChange{path: oldPath, remove: true})
+			pending[oldPath] = nil
+		}
+	}
+	return changes, summary, nil
+}
+
+// applyHunks applies the hunks of one file in order. Each hunk is looked for
+// at the line its header gives, shifted by how far off the previous hunk was,
+// and then at increasing distances from there, so patches with stale line
+// numbers still apply. Line endings are ignored when matching.
+func applyHunks(content string, hunks []patchHunk) (string, error) {
+	lines := []string{}
+	if content != "" {
+		lines = splitLinesKeepEnds(content)
+	}
+
+	result := strings.Builder{}
+	pos := 0
+	offset := 0
+	for i, hunk := range hunks {
+		expected := pos
+		if hunk.oldStart >= 0 {
+			expected = hunk.oldStart - 1 + offset
+			if len(hunk.oldLines) == 0 {
+				// a hunk that only adds lines goes after line oldStart
+				expected++
+			}
+		}
+
+		at := -1
+		if len(hunk.oldLines) == 0 {
+			at = min(max(expected, pos), len(lines))
+		} else {
+			at = findHunk(lines, hunk.oldLines, expected, pos)
+		}
+		if at == -1 {
+			return "", fmt.Errorf("hunk %d of %d does not match the file", i+1, len(hunks))
+		}
+		if hunk.oldStart >= 0 {
+			offset = at - expected + offset
+		}
+
+		for _, line := range lines[pos:at] {
+			result.WriteString(line)
+		}
+		for _, line := range hunk.newLines {
+			result.WriteString(line)
+		}
+		pos = at + len(hunk.oldLines)
+	}
+	for _, line := range lines[pos:] {
+		result.WriteString(line)
+	}
+	return result.String(), nil
+}
+
+// findHunk returns the index in lines, at or after from, where want matches
+// closest to expected, or -1 if it matches nowhere.
+func findHunk(lines, want []string, expected, from int) int {
+	last := len(lines) - len(want)
+	if last < from {
+		return -1
+	}
+	expected = min(max(expected, from), last)
+	for distance := 0; expected-distance >= from || expected+distance <= last; distance++ {
+		if at := expected - distance; at >= from && linesMatch(lines[at:at+len(want)], want) {
+			return at
+		}
+		if at := expected + distance; distance > 0 && at <= last && linesMatch(lines[at:at+len(want)], want) {
+			return at
+		}
+	}
+	return -1
+}
+
+func linesMatch(lines, want []string) bool {
+	for i := range want {
+		if strings.TrimRight(lines[i], "\r\n") != strings.TrimRight(want[i], "\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// parsePatch splits a unified diff into the changes it makes to each file.
+func parsePatch(patch string) ([]*filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	files := []*filePatch{}
+	var current *filePatch
+	isFileHeader := func(i int) bool {
+		return strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = &filePatch{}
+			oldPath, newPath, ok := strings.Cut(strings.TrimPrefix(line, "diff --git "), " b/")
+			if ok {
+				current.oldPath = patchPath(oldPath)
+				current.newPath = newPath
+			}
+			files = append(files, current)
+			i++
+		case current != nil && strings.HasPrefix(line, "new file mode"):
+			current.oldPath = ""
+			i++
+		case current != nil && strings.HasPrefix(line, "deleted file mode"):
+			current.newPath = ""
+			i++
+		case current != nil && strings.HasPrefix(line, "rename from "):
+			current.oldPath = strings.TrimPrefix(line, "rename from ")
+			i++
+		case current != nil && strings.HasPrefix(line, "rename to "):
+			current.newPath = strings.TrimPrefix(line, "rename to ")
+			i++
+		case isFileHeader(i):
+			if current == nil || current.headerSeen {
+				current = &filePatch{}
+				files = append(files, current)
+			}
+			current.oldPath = patchPath(strings.TrimPrefix(line, "--- "))
+			current.newPath = patchPath(strings.TrimPrefix(lines[i+1], "+++ "))
+			current.headerSeen = true
+			i += 2
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk before any file header", i+1)
+			}
+			hunk := patchHunk{oldStart: -1}
+			// a hunk with line counts is exactly that many lines, so its lines
+			// may look like headers; one without ends at the next header
+			counted := false
+			oldCount, newCount := 0, 0
+			if match := hunkHeaderRegexp.FindStringSubmatch(line); match != nil {
+				hunk.oldStart, _ = strconv.Atoi(match[1])
+				oldCount, newCount = hunkLineCount(match[2]), hunkLineCount(match[3])
+				counted = true
+			}
+			header := i
+			i++
+			lastOp := byte(' ')
+			for ; i < len(lines); i++ {
+				body := lines[i]
+				if counted && len(hunk.oldLines) == oldCount && len(hunk.newLines) == newCount && !strings.HasPrefix(body, "\\") {
+					break
+				}
+				if !counted && (strings.HasPrefix(body, "@@") || strings.HasPrefix(body, "diff --git ") || isFileHeader(i)) {
+					break
+				}
+				op := byte(' ')
+				if body != "" {
+					op = body[0]
+				}
+				switch op {
+				case ' ':
+					text := strings.TrimPrefix(body, " ") + "\n"
+					hunk.oldLines = append(hunk.oldLines, text)
+					hunk.newLines = append(hunk.newLines, text)
+				case '-':
+					hunk.oldLines = append(hunk.oldLines, body[1:]+"\n")
+					hunk.removed++
+				case '+':
+					hunk.newLines = append(hunk.newLines, body[1:]+"\n")
+					hunk.added++
+				case '\\':
+					// "\ No newline at end of file" applies to the line before it
+					if lastOp != '+' && len(hunk.oldLines) > 0 {
+						hunk.oldLines[len(hunk.oldLines)-1] = strings.TrimSuffix(hunk.oldLines[len(hunk.oldLines)-1], "\n")
+					}
+					if lastOp != '-' && len(hunk.newLines) > 0 {
+						hunk.newLines[len(hunk.newLines)-1] = strings.TrimSuffix(hunk.newLines[len(hunk.newLines)-1], "\n")
+					}
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line in hunk: %q", i+1, body)
+				}
+				if op != '\\' {
+					lastOp = op
+				}
+				if counted && (len(hunk.oldLines) > oldCount || len(hunk.newLines) > newCount) {
+					return nil, fmt.Errorf("line %d: the hunk has more lines than its header %q says", i+1, lines[header])
+				}
+			}
+			if counted && (len(hunk.oldLines) != oldCount || len(hunk.newLines) != newCount) {
+				return nil, fmt.Errorf("line %d: the hunk has fewer lines than its header %q says", header+1, lines[header])
+			}
+			if counted && i < len(lines) && isHunkLine(lines[i]) && !isFileHeader(i) {
+				return nil, fmt.Errorf("line %d: the hunk has more lines than its header %q says", i+1, lines[header])
+			}
+			current.hunks = append(current.hunks, hunk)
+		default:
+			// index, mode and similarity lines, or text around the diff
+			i++
+		}
+	}
+
+	files = dropEmptyFilePatches(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("the patch has no file changes")
+	}
+	return files, nil
+}
+
+// hunkLineCount parses the line count of a hunk header, which is 1 when left out.
+func hunkLineCount(count string) int {
+	if count == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(count)
+	return n
+}
+
+// isHunkLine reports whether a line after a hunk looks like it was meant to
+// be part of it. Blank lines and the "-- " that ends a mailed patch aren't.
+func isHunkLine(line string) bool {
+	if line == "-- " || line == "--" {
+		return false
+	}
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")
+}
+
+// dropEmptyFilePatches removes the file patches that change nothing, such as
+// git's mode-only changes.
+func dropEmptyFilePatches(files []*filePatch) []*filePatch {
+	kept := []*filePatch{}
+	for _, file := range files {
+		if len(file.hunks) == 0 && file.oldPath == file.newPath {
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// patchPath turns a path from a '---' or '+++' line into a workspace path. It
+// drops any timestamp and the a/ or b/ prefix, and maps /dev/null to "".
+func patchPath(p string) string {
+	p, _, _ = strings.Cut(p, "\t")
+	p = strings.TrimSpace(p)
+	if p == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+		return p[2:]
+	}
+	return p
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	defer func(previous *Workspace) { workspace = previous }(workspace)
+	workspace = w
+
+	root := w.Root()
+	os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n"), 0644)
+	os.WriteFile(filepath.Join(root, "old.txt"), []byte("one\ntwo\n"), 0644)
+	os.WriteFile(filepath.Join(root, "gone.txt"), []byte("bye\n"), 0644)
+
+	// happy path: the hunk's line numbers are off by two
+	patch := `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -7,3 +7,3 @@
+ func main() {
+-	fmt.Println("hi")
++	fmt.Println("hello")
+ }
+--- /dev/null
++++ b/pkg/new.txt
+@@ -0,0 +1,2 @@
++first
++second
+diff --git a/old.txt b/renamed.txt
+similarity index 50%
+rename from old.txt
+rename to renamed.txt
+--- a/old.txt
++++ b/renamed.txt
+@@ -1,2 +1,2 @@
+ one
+-two
++three
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+`
+	input, _ := json.Marshal(ApplyPatchInput{Patch: patch})
+	result, err := ApplyPatch(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to apply patch: %v", err)
+	}
+	expected := "Applied patch to 4 file(s):\nmodified main.go (+1 -1)\ncreated pkg/new.txt (+2 -0)\nrenamed old.txt -> renamed.txt (+1 -1)\ndeleted gone.txt (+0 -1)"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+	files := map[string]string{
+		"main.go":     "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n",
+		"pkg/new.txt": "first\nsecond\n",
+		"renamed.txt": "one\nthree\n",
+	}
+	for name, want := range files {
+		content, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if string(content) != want {
+			t.Fatalf("expected %q in %s, got %q", want, name, content)
+		}
+	}
+	for _, name := range []string{"old.txt", "gone.txt"} {
+		_, err = os.Stat(filepath.Join(root, name))
+		if !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", name)
+		}
+	}
+
+	// test a failing hunk rejects the whole patch
+	patch = `--- a/renamed.txt
++++ b/renamed.txt
+@@ -1,2 +1,2 @@
+-one
++uno
+ three
+--- a/main.go
++++ b/main.go
+@@ -1 +1 @@
+-package foo
++package bar
+`
+	input, _ = json.Marshal(ApplyPatchInput{Patch: patch})
+	_, err = ApplyPatch(context.Background(), input)
+	if err == nil || !strings.Contains(err.Error(), "main.go: hunk 1 of 1 does not match the file") {
+		t.Fatalf("expected main.go to fail, got %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(root, "renamed.txt"))
+	if string(content) != "one\nthree\n" {
+		t.Fatalf("expected renamed.txt to be unchanged, got %q", content)
+	}
+
+	// test a write failing part way through restores the files written before it
+	patch = `--- /dev/null
++++ b/blocker
+@@ -0,0 +1 @@
++file
+--- a/renamed.txt
++++ b/renamed.txt
+@@ -1,2 +1,2 @@
+-one
++uno
+ three
+--- /dev/null
++++ b/blocker/new.txt
+@@ -0,0 +1 @@
++nope
+`
+	input, _ = json.Marshal(ApplyPatchInput{Patch: patch})
+	_, err = ApplyPatch(context.Background(), input)
+	if err == nil || !strings.Contains(err.Error(), "failed to update blocker/new.txt") {
+		t.Fatalf("expected the last write to fail, got %v", err)
+	}
+	content, _ = os.ReadFile(filepath.Join(root, "renamed.txt"))
+	if string(content) != "one\nthree\n" {
+		t.Fatalf("expected renamed.txt to be restored, got %q", content)
+	}
+	_, err = os.Stat(filepath.Join(root, "blocker"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the created file to be removed, got %v", err)
+	}
+
+	// test creating a file that already exists
+	patch = "--- /dev/null\n+++ b/main.go\n@@ -0,0 +1 @@\n+package main\n"
+	input, _ = json.Marshal(ApplyPatchInput{Patch: patch})
+	_, err = ApplyPatch(context.Background(), input)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test a path outside the workspace
+	patch = "--- /dev/null\n+++ b/../outside.txt\n@@ -0,0 +1 @@\n+nope\n"
+	input, _ = json.Marshal(ApplyPatchInput{Patch: patch})
+	_, err = ApplyPatch(context.Background(), input)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test a patch without any file changes
+	input, _ = json.Marshal(ApplyPatchInput{Patch: "just some text\n"})
+	_, err = ApplyPatch(context.Background(), input)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	content := "a\nb\nc\nd\ne\nf\n"
+
+	// test the second hunk follows the offset of the first and the missing newline marker
+	files, err := parsePatch(`--- a/x
++++ b/x
+@@ -3,2 +3,2 @@
+ d
+-e
++E
+@@ -5 +5 @@
+-f
++F
+\ No newline at end of file
+`)
+	if err != nil {
+		t.Fatalf("failed to parse patch: %v", err)
+	}
+	result, err := applyHunks(content, files[0].hunks)
+	if err != nil {
+		t.Fatalf("failed to apply hunks: %v", err)
+	}
+	if result != "a\nb\nc\nd\nE\nF" {
+		t.Fatalf("expected %q, got %q", "a\nb\nc\nd\nE\nF", result)
+	}
+
+	// test a hunk without line numbers
+	files, err = parsePatch("--- a/x\n+++ b/x\n@@\n b\n+b2\n c\n")
+	if err != nil {
+		t.Fatalf("failed to parse patch: %v", err)
+	}
+	result, err = applyHunks(content, files[0].hunks)
+	if err != nil {
+		t.Fatalf("failed to apply hunks: %v", err)
+	}
+	if result != "a\nb\nb2\nc\nd\ne\nf\n" {
+		t.Fatalf("expected %q, got %q", "a\nb\nb2\nc\nd\ne\nf\n", result)
+	}
+
+	// test lines of a counted hunk that look like file headers are content
+	files, err = parsePatch("--- a/notes.md\n+++ b/notes.md\n@@ -1,3 +1,3 @@\n title\n--- old rule\n+++ new rule\n end\n")
+	if err != nil {
+		t.Fatalf("failed to parse patch: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one file, got %d", len(files))
+	}
+	result, err = applyHunks("title\n-- old rule\nend\n", files[0].hunks)
+	if err != nil {
+		t.Fatalf("failed to apply hunks: %v", err)
+	}
+	if result != "title\n++ new rule\nend\n" {
+		t.Fatalf("expected %q, got %q", "title\n++ new rule\nend\n", result)
+	}
+
+	// test hunks whose lines don't match their header's counts are rejected
+	for _, patch := range []string{
+		"--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n a\n-b\n+c\n",
+		"--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n c\n",
+		"--- a/x\n+++ b/x\n@@ -1,2 +1,1 @@\n a\n-b\n+c\n",
+	} {
+		_, err = parsePatch(patch)
+		if err == nil || !strings.Contains(err.Error(), "than its header") {
+			t.Fatalf("expected a count mismatch for %q, got %v", patch, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// fileSnapshot is the content and mode of a file before a turn first changed it.
+type fileSnapshot struct {
+	content []byte
+	mode    os.FileMode
+	existed bool
+}
+
+// readSnapshot saves the current content of path, which may not exist.
+func readSnapshot(path string) (fileSnapshot, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fileSnapshot{existed: false}, nil
+	}
+	if err != nil {
+		return fileSnapshot{}, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileSnapshot{}, err
+	}
+	return fileSnapshot{content: content, mode: info.Mode().Perm(), existed: true}, nil
+}
+
+// restore puts path back the way the snapshot found it.
+func (s fileSnapshot) restore(path string) error {
+	if s.existed {
+		err := os.WriteFile(path, s.content, s.mode)
+		if err != nil {
+			return err
+		}
+		// the file may have been recreated in the meantime, or had its mode changed
+		return os.Chmod(path, s.mode)
+	}
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Checkpoint records the state to go back to in order to undo one user turn:
+// the files as they were before the turn touched them, the directories it
+// created and the length of the conversation before the turn's prompt.
+type Checkpoint struct {
+	Turn   int
+	Prompt string
+	// conversationLen is -1 once the history was rewritten (e.g. compacted)
+	// and can no longer be cut back to this point.
+	conversationLen int
+	files           map[string]fileSnapshot
+	// dirs are the directories the turn created, parents first
+	dirs []string
+}
+
+// Files returns the paths the turn changed, sorted.
+func (c *Checkpoint) Files() []string {
+	paths := make([]string, 0, len(c.files))
+	for path := range c.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Checkpoints keeps one checkpoint per user turn. Every file write the tools
+// make goes through Snapshot first, so the changes of any turn can be undone.
+type Checkpoints struct {
+	checkpoints []*Checkpoint
+	nextTurn    int
+}
+
+func NewCheckpoints() *Checkpoints {
+	return &Checkpoints{nextTurn: 1}
+}
+
+// Begin starts the checkpoint for a new user turn.
+func (c *Checkpoints) Begin(prompt string, conversationLen int) {
+	c.checkpoints = append(c.checkpoints, &Checkpoint{
+		Turn:            c.nextTurn,
+		Prompt:          prompt,
+		conversationLen: conversationLen,
+		files:           map[string]fileSnapshot{},
+	})
+	c.nextTurn++
+}
+
+// Snapshot saves the current content of path in the current checkpoint, unless
+// the turn already saved it. Nothing is saved before the first turn begins.
+func (c *Checkpoints) Snapshot(path string) error {
+	if len(c.checkpoints) == 0 {
+		return nil
+	}
+	current := c.checkpoints[len(c.checkpoints)-1]
+	if _, ok := current.files[path]; ok {
+		return nil
+	}
+
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	current.files[path] = snapshot
+	return nil
+}
+
+// CreatedDir records that the current turn created the directory, so that
+// undoing the turn removes it again if it is empty by then.
+func (c *Checkpoints) CreatedDir(dir string) {
+	if len(c.checkpoints) == 0 {
+		return
+	}
+	current := c.checkpoints[len(c.checkpoints)-1]
+	current.dirs = append(current.dirs, dir)
+}
+
+// List returns the checkpoints, oldest first.
+func (c *Checkpoints) List() []*Checkpoint {
+	return c.checkpoints
+}
+
+// Last returns the most recent checkpoint, or nil if there is none.
+func (c *Checkpoints) Last() *Checkpoint {
+	if len(c.checkpoints) == 0 {
+		return nil
+	}
+	return c.checkpoints[len(c.checkpoints)-1]
+}
+
+// Rewind restores every file changed since the start of the given turn and drops
+// the checkpoints of that turn and the ones after it. It returns the checkpoint
+// of the turn, whose conversationLen is where the history can be cut back to.
+func (c *Checkpoints) Rewind(turn int) (*Checkpoint, error) {
+	index := -1
+	for i, checkpoint := range c.checkpoints {
+		if checkpoint.Turn == turn {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("no checkpoint for turn %d", turn)
+	}
+
+	// restore newest first so each file ends up as the earliest snapshot left it
+	for i := len(c.checkpoints) - 1; i >= index; i-- {
+		for path, snapshot := range c.checkpoints[i].files {
+			err := snapshot.restore(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore %s: %w", path, err)
+			}
+		}
+		// children first; a directory that isn't empty now holds files the turn
+		// didn't create, so it stays
+		dirs := c.checkpoints[i].dirs
+		for j := len(dirs) - 1; j >= 0; j-- {
+			os.Remove(dirs[j])
+		}
+	}
+
+	checkpoint := c.checkpoints[index]
+	c.checkpoints = c.checkpoints[:index]
+	return checkpoint, nil
+}
+
+// ForgetHistory marks every checkpoint as unable to cut the conversation back,
+// for when the history was rewritten. Their file snapshots stay usable.
+func (c *Checkpoints) ForgetHistory() {
+	for _, checkpoint := range c.checkpoints {
+		checkpoint.conversationLen = -1
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	existing := filepath.Join(w.Root(), "existing.txt")
+	created := filepath.Join(w.Root(), "created.txt")
+	os.WriteFile(existing, []byte("original"), 0644)
+
+	// turn 1 edits a file twice, turn 2 edits it again and creates another
+	checkpoints := w.Checkpoints()
+	checkpoints.Begin("first", 0)
+	w.WriteFile(existing, []byte("turn 1 a"))
+	w.WriteFile(existing, []byte("turn 1 b"))
+	checkpoints.Begin("second", 2)
+	w.WriteFile(existing, []byte("turn 2"))
+	w.WriteFile(created, []byte("new"))
+
+	// happy path: undoing turn 2 goes back to the end of turn 1
+	checkpoint, err := checkpoints.Rewind(2)
+	if err != nil {
+		t.Fatalf("failed to rewind: %v", err)
+	}
+	if checkpoint.conversationLen != 2 || len(checkpoint.Files()) != 2 {
+		t.Fatalf("unexpected checkpoint: %+v", checkpoint)
+	}
+	content, _ := os.ReadFile(existing)
+	if string(content) != "turn 1 b" {
+		t.Fatalf("expected turn 1 b, got %s", content)
+	}
+	_, err = os.Stat(created)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the created file to be removed")
+	}
+
+	// test rewinding turn 1 restores the original content
+	_, err = checkpoints.Rewind(1)
+	if err != nil {
+		t.Fatalf("failed to rewind: %v", err)
+	}
+	content, _ = os.ReadFile(existing)
+	if string(content) != "original" {
+		t.Fatalf("expected original, got %s", content)
+	}
+
+	// test rewinding a turn that was already undone
+	_, err = checkpoints.Rewind(2)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if checkpoints.Last() != nil {
+		t.Fatalf("expected no checkpoints left")
+	}
+}
+
+func TestCheckpointModesAndDirs(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	script := filepath.Join(w.Root(), "build.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\n"), 0755)
+	os.Mkdir(filepath.Join(w.Root(), "kept"), 0755)
+	checkpoints := w.Checkpoints()
+	checkpoints.Begin("first", 0)
+
+	// happy path: undoing a turn that replaced an executable keeps it executable
+	w.RemoveFile(script)
+	w.WriteFile(script, []byte("echo replaced\n"))
+	// test directories the turn created are removed, unless something else is in them
+	created := filepath.Join(w.Root(), "a", "b")
+	err = w.MkdirAll(created)
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	w.WriteFile(filepath.Join(created, "new.go"), []byte("package b\n"))
+	w.MkdirAll(filepath.Join(w.Root(), "kept", "c"))
+	os.WriteFile(filepath.Join(w.Root(), "kept", "c", "untracked.txt"), []byte("mine"), 0644)
+
+	_, err = checkpoints.Rewind(1)
+	if err != nil {
+		t.Fatalf("failed to rewind: %v", err)
+	}
+	info, err := os.Stat(script)
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("expected the script to be executable again, got %v and %v", info, err)
+	}
+	content, _ := os.ReadFile(script)
+	if string(content) != "#!/bin/sh\n" {
+		t.Fatalf("expected the original script, got %s", content)
+	}
+	_, err = os.Stat(filepath.Join(w.Root(), "a"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the created directories to be removed")
+	}
+	_, err = os.Stat(filepath.Join(w.Root(), "kept", "c", "untracked.txt"))
+	if err != nil {
+		t.Fatalf("expected a directory with other files to stay: %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// SlashCommand is a REPL command typed as "/name args" instead of a message to Claude.
+type SlashCommand struct {
+	Name        string
+	Description string
+	// Run handles the command. A non-empty prompt is sent to Claude as the user's message.
+	Run func(ctx context.Context, a *Agent, args string) (prompt string, err error)
+}
+
+// errExit is returned by a command to end the REPL.
+var errExit = errors.New("exit")
+
+func builtinCommands() []SlashCommand {
+	return []SlashCommand{
+		{Name: "help", Description: "List the available commands", Run: helpCommand},
+		{Name: "clear", Description: "Start a new conversation", Run: clearCommand},
+		{Name: "compact", Description: "Replace the conversation so far with a summary", Run: compactCommand},
+		{Name: "model", Description: "Show the current model, or switch to another: /model <id>", Run: modelCommand},
+		{Name: "tools", Description: "List the tools Claude can use", Run: toolsCommand},
+		{Name: "cost", Description: "Show token usage and estimated cost for this session", Run: costCommand},
+		{Name: "save", Description: "Save the conversation as a markdown transcript: /save [path]", Run: saveCommand},
+		{Name: "undo", Description: "Undo the last turn's file changes and remove it from the conversation (--files keeps the conversation)", Run: undoCommand},
+		{Name: "rewind", Description: "List turns, or undo everything since a turn: /rewind <turn> [--files]", Run: rewindCommand},
+		{Name: "exit", Description: "Quit the agent", Run: exitCommand},
+	}
+}
+
+// isSlashCommand reports whether the line should go to the command dispatcher
+// rather than to Claude. A leading path such as "/usr/bin" is not a command.
+func isSlashCommand(line string) bool {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "/") {
+		return false
+	}
+	name, _, _ := strings.Cut(line[1:], " ")
+	return name != "" && !strings.Contains(name, "/")
+}
+
+// runSlashCommand dispatches a line starting with "/" to the matching command.
+func (a *Agent) runSlashCommand(ctx context.Context, line string) (string, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(line)[1:], " ")
+	for _, command := range a.commands {
+		if command.Name == name {
+			return command.Run(ctx, a, strings.TrimSpace(args))
+		}
+	}
+	return "", fmt.Errorf("unknown command /%s, type /help to list commands", name)
+}
+
+// RegisterCommands adds commands to the REPL. Commands registered earlier win on a name clash.
+func (a *Agent) RegisterCommands(commands []SlashCommand) {
+	a.commands = append(a.commands, commands...)
+}
+
+func helpCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	for _, command := range a.commands {
+		fmt.Printf("  /%-10s %s\n", command.Name, command.Description)
+	}
+	return "", nil
+}
+
+func clearCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	a.conversation = []anthropic.MessageParam{}
+	a.contextTokens = 0
+	workspace.Checkpoints().ForgetHistory()
+	// keep the old session on disk so it can still be resumed
+	if a.session != nil {
+		session, err := a.session.store.Create()
+		if err != nil {
+			return "", err
+		}
+		a.session = session
+	}
+	fmt.Println("Started a new conversation")
+	return "", nil
+}
+
+func compactCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	return "", a.compact(ctx, 0)
+}
+
+func modelCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	if args == "" {
+		fmt.Printf("Current model: %s\n", a.config.Model)
+		return "", nil
+	}
+	a.config.Model = args
+	fmt.Printf("Switched model to %s\n", a.config.Model)
+	return "", nil
+}
+
+func toolsCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	for _, tool := range a.tools {
+		description, _, _ := strings.Cut(strings.TrimSpace(tool.Description), "\n")
+		fmt.Printf("  %-16s %s\n", tool.Name, description)
+	}
+	return "", nil
+}
+
+// modelPricing is the price in dollars per million tokens, keyed by model ID prefix.
+var modelPricing = []struct {
+	prefix                               string
+	input, output, cacheWrite, cacheRead float64
+}{
+	{"claude-opus-4", 15, 75, 18.75, 1.50},
+	{"claude-sonnet-4", 3, 15, 3.75, 0.30},
+	{"claude-3-7-sonnet", 3, 15, 3.75, 0.30},
+	{"claude-3-5-sonnet", 3, 15, 3.75, 0.30},
+	{"claude-3-5-haiku", 0.80, 4, 1, 0.08},
+	{"claude-3-haiku", 0.25, 1.25, 0.30, 0.03},
+}
+
+func costCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	usage := a.totalUsage
+	fmt.Printf("Input tokens:        %d\n", usage.InputTokens)
+	fmt.Printf("Output tokens:       %d\n", usage.OutputTokens)
+	fmt.Printf("Cache write tokens:  %d\n", usage.CacheCreationInputTokens)
+	fmt.Printf("Cache read tokens:   %d\n", usage.CacheReadInputTokens)
+
+	for _, pricing := range modelPricing {
+		if strings.HasPrefix(a.config.Model, pricing.prefix) {
+			cost := (float64(usage.InputTokens)*pricing.input +
+				float64(usage.OutputTokens)*pricing.output +
+				float64(usage.CacheCreationInputTokens)*pricing.cacheWrite +
+				float64(usage.CacheReadInputTokens)*pricing.cacheRead) / 1e6
+			fmt.Printf("Estimated cost:      $%.4f (at %s prices)\n", cost, a.config.Model)
+			return "", nil
+		}
+	}
+	fmt.Printf("No pricing known for %s\n", a.config.Model)
+	return "", nil
+}
+
+func saveCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	path := args
+	if path == "" {
+		name := "transcript"
+		if a.session != nil {
+			name = a.session.ID
+		}
+		path = filepath.Join(projectDir, "transcripts", name+".md")
+	}
+
+	transcript := strings.Builder{}
+	for _, message := range a.conversation {
+		for _, block := range message.Content {
+			switch {
+			case block.OfText != nil:
+				fmt.Fprintf(&transcript, "## %s\n\n%s\n\n", message.Role, block.OfText.Text)
+			case block.OfToolUse != nil:
+				fmt.Fprintf(&transcript, "## tool call: %s\n\n```json\n%s\n```\n\n", block.OfToolUse.Name, toJSON(block.OfToolUse.Input))
+			case block.OfToolResult != nil:
+				for _, content := range block.OfToolResult.Content {
+					if content.OfText != nil {
+						fmt.Fprintf(&transcript, "## tool result\n\n```\n%s\n```\n\n", content.OfText.Text)
+					}
+				}
+			}
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if dir != "." {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return "", err
+		}
+	}
+	err := os.WriteFile(path, []byte(transcript.String()), 0644)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Saved transcript to %s\n", path)
+	return "", nil
+}
+
+func undoCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	last := workspace.Checkpoints().Last()
+	if last != nil {
+		return "", a.rewind(last.Turn, args == "--files")
+	}
+
+	// without a checkpoint (e.g. the turn is from a resumed session) only the conversation can be undone;
+	// the last turn starts at the last user message that isn't a tool result
+	for i := len(a.conversation) - 1; i >= 0; i-- {
+		message := a.conversation[i]
+		if message.Role == anthropic.MessageParamRoleUser && !hasToolResult(message) {
+			a.replaceConversation(a.conversation[:i])
+			a.contextTokens = 0
+			fmt.Printf("Removed the last turn: %s\n", messageText(message))
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("nothing to undo")
+}
+
+func rewindCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		checkpoints := workspace.Checkpoints().List()
+		if len(checkpoints) == 0 {
+			fmt.Println("No turns to rewind to")
+		}
+		for _, checkpoint := range checkpoints {
+			fmt.Printf("  %3d  %-50s  %d files changed\n", checkpoint.Turn, shortenPrompt(checkpoint.Prompt, 50), len(checkpoint.files))
+		}
+		return "", nil
+	}
+
+	turn, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid turn %q", fields[0])
+	}
+	return "", a.rewind(turn, len(fields) > 1 && fields[1] == "--files")
+}
+
+// rewind restores the files changed since the start of the turn and, unless
+// filesOnly is set, cuts the conversation back to just before the turn's prompt.
+func (a *Agent) rewind(turn int, filesOnly bool) error {
+	checkpoint, err := workspace.Checkpoints().Rewind(turn)
+	if err != nil {
+		return err
+	}
+	for _, path := range checkpoint.Files() {
+		fmt.Printf("Restored %s\n", workspace.Rel(path))
+	}
+	if filesOnly {
+		return nil
+	}
+
+	if checkpoint.conversationLen < 0 || checkpoint.conversationLen > len(a.conversation) {
+		fmt.Println("The conversation was rewritten since that turn, so only its files were restored")
+		return nil
+	}
+	a.replaceConversation(a.conversation[:checkpoint.conversationLen])
+	a.contextTokens = 0
+	fmt.Printf("Rewound the conversation to before turn %d: %s\n", checkpoint.Turn, checkpoint.Prompt)
+	return nil
+}
+
+func exitCommand(ctx context.Context, a *Agent, args string) (string, error) {
+	return "", errExit
+}
+
+// loadCustomCommands reads user-defined commands from markdown prompt templates
+// in dir. The file name is the command name, an optional front matter block can
+// set its description, and $ARGUMENTS, $1, $2, ... in the body are replaced by
+// the command's arguments.
+func loadCustomCommands(dir string) ([]SlashCommand, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	commands := []SlashCommand{}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".md")
+		description, template := parseCommandTemplate(string(content))
+		if description == "" {
+			description = "Custom command from " + path
+		}
+		commands = append(commands, SlashCommand{
+			Name:        name,
+			Description: description,
+			Run: func(ctx context.Context, a *Agent, args string) (string, error) {
+				return expandCommandTemplate(template, args), nil
+			},
+		})
+	}
+	return commands, nil
+}
+
+// parseCommandTemplate splits an optional "---" delimited front matter block from the template body.
+func parseCommandTemplate(content string) (description, template string) {
+	if !strings.HasPrefix(content, "---\n") {
+		return "", content
+	}
+	frontMatter, body, found := strings.Cut(content[len("---\n"):], "\n---\n")
+	if !found {
+		return "", content
+	}
+	for _, line := range strings.Split(frontMatter, "\n") {
+		key, value, _ := strings.Cut(line, ":")
+		if strings.TrimSpace(key) == "description" {
+			description = strings.TrimSpace(value)
+		}
+	}
+	return description, body
+}
+
+var commandArgPattern = regexp.MustCompile(`\$(ARGUMENTS|[1-9])`)
+
+// expandCommandTemplate substitutes $ARGUMENTS and $1 to $9 in one pass over
+// the template, so placeholders inside the arguments are left as they are.
+func expandCommandTemplate(template, args string) string {
+	fields := strings.Fields(args)
+	prompt := commandArgPattern.ReplaceAllStringFunc(template, func(match string) string {
+		if match == "$ARGUMENTS" {
+			return args
+		}
+		i := int(match[1] - '1')
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	})
+	return strings.TrimSpace(prompt)
+}
+
+func toJSON(v any) string {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(content)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCustomCommands(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "review.md"), []byte("---\ndescription: Review a file\n---\nReview $1 focusing on $2.\nNotes: $ARGUMENTS\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "plain.md"), []byte("Explain the project layout."), 0644)
+
+	commands, err := loadCustomCommands(dir)
+	if err != nil {
+		t.Fatalf("failed to load commands: %v", err)
+	}
+	if len(commands) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(commands))
+	}
+
+	// happy path: front matter sets the description and arguments are substituted
+	agent := &Agent{commands: append(builtinCommands(), commands...)}
+	prompt, err := agent.runSlashCommand(context.Background(), "/review main.go errors")
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	expected := "Review main.go focusing on errors.\nNotes: main.go errors"
+	if prompt != expected {
+		t.Fatalf("expected %q, got %q", expected, prompt)
+	}
+	if commands[1].Description != "Review a file" {
+		t.Fatalf("expected description from front matter, got %q", commands[1].Description)
+	}
+
+	// test missing positional arguments are left empty
+	prompt, err = agent.runSlashCommand(context.Background(), "/review main.go")
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	expected = "Review main.go focusing on .\nNotes: main.go"
+	if prompt != expected {
+		t.Fatalf("expected %q, got %q", expected, prompt)
+	}
+
+	// test placeholders in the arguments aren't substituted again
+	prompt, err = agent.runSlashCommand(context.Background(), "/review cost$2 $1")
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	expected = "Review cost$2 focusing on $1.\nNotes: cost$2 $1"
+	if prompt != expected {
+		t.Fatalf("expected %q, got %q", expected, prompt)
+	}
+
+	// test a template without placeholders is used as is
+	prompt, err = agent.runSlashCommand(context.Background(), "/plain")
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	if prompt != "Explain the project layout." {
+		t.Fatalf("expected the template unchanged, got %q", prompt)
+	}
+
+	// test unknown commands
+	_, err = agent.runSlashCommand(context.Background(), "/missing")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test paths are not treated as commands
+	if isSlashCommand("/usr/bin/env is missing") {
+		t.Fatalf("expected a path not to be a command")
+	}
+	if !isSlashCommand("/exit") {
+		t.Fatalf("expected /exit to be a command")
+	}
+}
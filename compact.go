@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// compactKeepTurns is how many of the most recent user turns are kept verbatim
+// when the conversation is compacted automatically.
+const compactKeepTurns = 2
+
+const compactPrompt = `Summarize the conversation so far so that it can replace the earlier messages in your context.
+
+Include the user's goals and instructions, the decisions that were made, the files that were read or changed and what changed in them, and any work that is still pending. Be concise but keep every detail needed to continue the task. Reply with the summary only.`
+
+// compact replaces everything before the last keepTurns user turns with a
+// summary written by the model. Splitting only at the start of a user turn, or
+// after a tool_result, keeps every tool_use block next to its tool_result.
+func (a *Agent) compact(ctx context.Context, keepTurns int) error {
+	split := compactionSplit(a.conversation, keepTurns)
+	if split == 0 {
+		return fmt.Errorf("not enough conversation history to compact")
+	}
+	older := a.conversation[:split]
+
+	fmt.Printf("\u001b[92mcompact\u001b[0m: summarizing %d messages...\n", len(older))
+
+	request := append([]anthropic.MessageParam{}, older...)
+	if last := request[len(request)-1]; last.Role == anthropic.MessageParamRoleUser {
+		// a split inside a turn leaves tool results last, which the prompt joins so the roles still alternate
+		content := append(append([]anthropic.ContentBlockParamUnion{}, last.Content...), anthropic.NewTextBlock(compactPrompt))
+		request[len(request)-1] = anthropic.NewUserMessage(content...)
+	} else {
+		request = append(request, anthropic.NewUserMessage(anthropic.NewTextBlock(compactPrompt)))
+	}
+	inferenceRequest := a.inferenceRequest(request)
+	// the summary must be plain text
+	inferenceRequest.NoToolUse = true
+	inferenceRequest.StopSequences = nil
+
+	var message *anthropic.Message
+	err := a.retryPolicy.Do(ctx, func() error {
+		var err error
+		message, err = a.provider.Stream(ctx, inferenceRequest, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+	a.recordUsage(message.Usage)
+	summary := ""
+	for _, content := range message.Content {
+		if content.Type == "text" {
+			summary += content.Text
+		}
+	}
+	if summary == "" {
+		return fmt.Errorf("the model returned an empty summary")
+	}
+
+	summaryMessage := anthropic.NewUserMessage(anthropic.NewTextBlock("This conversation was compacted. Summary of the earlier messages:\n\n" + summary))
+	compacted := append([]anthropic.MessageParam{summaryMessage}, a.conversation[split:]...)
+	a.replaceConversation(compacted)
+	workspace.Checkpoints().ForgetHistory()
+	// the context size is unknown until the next response reports it
+	a.contextTokens = 0
+
+	fmt.Printf("\u001b[92mcompact\u001b[0m: replaced %d messages with a summary\n", len(older))
+	return nil
+}
+
+// compactionSplit returns the index of the first message to keep when
+// compacting, so that the last keepTurns user turns survive. A user turn starts
+// at a user message that is not a tool result. When there aren't enough turns,
+// e.g. in one long turn of tool calls, it keeps the last keepTurns tool calls
+// instead, splitting at the assistant message after a tool result. It returns
+// 0 if nothing can be compacted.
+func compactionSplit(conversation []anthropic.MessageParam, keepTurns int) int {
+	if keepTurns == 0 {
+		return len(conversation)
+	}
+
+	turnStarts := []int{}
+	afterToolResults := []int{}
+	for i, message := range conversation {
+		if message.Role == anthropic.MessageParamRoleUser && !hasToolResult(message) {
+			turnStarts = append(turnStarts, i)
+		}
+		if i > 0 && message.Role == anthropic.MessageParamRoleAssistant && hasToolResult(conversation[i-1]) {
+			afterToolResults = append(afterToolResults, i)
+		}
+	}
+	if len(turnStarts) > keepTurns {
+		return turnStarts[len(turnStarts)-keepTurns]
+	}
+	if len(afterToolResults) == 0 {
+		return 0
+	}
+	return afterToolResults[max(len(afterToolResults)-keepTurns, 0)]
+}
+
+func hasToolResult(message anthropic.MessageParam) bool {
+	for _, block := range message.Content {
+		if block.OfToolResult != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceConversation swaps in a rewritten history and keeps the session file in step with it.
+func (a *Agent) replaceConversation(conversation []anthropic.MessageParam) {
+	a.conversation = conversation
+	if a.session == nil {
+		return
+	}
+	err := a.session.Rewrite(conversation)
+	if err != nil {
+		fmt.Printf("\u001b[91mwarning\u001b[0m: failed to save session: %s\n", err.Error())
+	}
+}
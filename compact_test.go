@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+func TestCompactionSplit(t *testing.T) {
+	conversation := []anthropic.MessageParam{
+		anthropic.NewUserMessage(anthropic.NewTextBlock("first")),
+		anthropic.NewAssistantMessage(anthropic.NewToolUseBlock("toolu_1", json.RawMessage(`{}`), "list_files")),
+		anthropic.NewUserMessage(anthropic.NewToolResultBlock("toolu_1", "[]", false)),
+		anthropic.NewAssistantMessage(anthropic.NewTextBlock("nothing here")),
+		anthropic.NewUserMessage(anthropic.NewTextBlock("second")),
+		anthropic.NewAssistantMessage(anthropic.NewToolUseBlock("toolu_2", json.RawMessage(`{}`), "list_files")),
+		anthropic.NewUserMessage(anthropic.NewToolResultBlock("toolu_2", "[]", false)),
+	}
+
+	// happy path: keep the last turn, split at its first message rather than at the tool result
+	split := compactionSplit(conversation, 1)
+	if split != 4 {
+		t.Fatalf("expected 4, got %d", split)
+	}
+
+	// test keeping zero turns compacts everything
+	split = compactionSplit(conversation, 0)
+	if split != len(conversation) {
+		t.Fatalf("expected %d, got %d", len(conversation), split)
+	}
+
+	// test keeping as many turns as there are splits inside a turn, after a tool result
+	split = compactionSplit(conversation, 2)
+	if split != 3 {
+		t.Fatalf("expected 3, got %d", split)
+	}
+
+	// test a single long turn of tool calls keeps its last calls
+	conversation = []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("only"))}
+	for i := range 4 {
+		id := fmt.Sprintf("toolu_%d", i)
+		conversation = append(conversation,
+			anthropic.NewAssistantMessage(anthropic.NewToolUseBlock(id, json.RawMessage(`{}`), "list_files")),
+			anthropic.NewUserMessage(anthropic.NewToolResultBlock(id, "[]", false)),
+		)
+	}
+	split = compactionSplit(conversation, 2)
+	if split != 5 || conversation[split].Role != anthropic.MessageParamRoleAssistant {
+		t.Fatalf("expected 5, got %d", split)
+	}
+
+	// test a turn without tool calls leaves nothing to compact
+	split = compactionSplit(conversation[:1], 2)
+	if split != 0 {
+		t.Fatalf("expected 0, got %d", split)
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// projectDir is the per-project directory (relative to the working directory)
+// that holds the agent's configuration and state.
+const projectDir = ".agent"
+
+// Config controls how the agent talks to the model.
+//
+// Values are layered: built-in defaults, then the config file, then AGENT_*
+// environment variables, then command line flags.
+type Config struct {
+	// Provider is the API the agent talks to: "anthropic", or "openai" for
+	// servers with an OpenAI-compatible chat completions API, such as
+	// llama.cpp or Ollama. BaseURL overrides where its API is.
+	Provider         string   `json:"provider,omitempty"`
+	BaseURL          string   `json:"base_url,omitempty"`
+	Model            string   `json:"model,omitempty"`
+	SystemPrompt     string   `json:"system_prompt,omitempty"`
+	SystemPromptFile string   `json:"system_prompt_file,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	MaxTokens        int64    `json:"max_tokens,omitempty"`
+	StopSequences    []string `json:"stop_sequences,omitempty"`
+	// CompactThreshold is the context size in tokens past which older turns are
+	// replaced with a summary. Zero disables automatic compaction.
+	CompactThreshold *int64 `json:"compact_threshold,omitempty"`
+	// CommandTimeout is the default run_command timeout in seconds.
+	CommandTimeout int `json:"command_timeout,omitempty"`
+	// WorkspaceRoot is the directory the tools are confined to, and AllowedDirs
+	// are extra directories outside of it that they may also access.
+	WorkspaceRoot string   `json:"workspace_root,omitempty"`
+	AllowedDirs   []string `json:"allowed_dirs,omitempty"`
+	// DiffMaxBytes is the largest file size edits show a diff for. Zero turns diffs off.
+	DiffMaxBytes *int `json:"diff_max_bytes,omitempty"`
+	// PostEditGo turns on formatting, import fixing and type checking of Go
+	// files after the tools change them.
+	PostEditGo *bool `json:"post_edit_go,omitempty"`
+	// PostEditHooks are commands to run on changed files, set in the config
+	// file only. Hooks from the project's config file only run once the user
+	// approved them.
+	PostEditHooks []PostEditCommand `json:"post_edit_hooks,omitempty"`
+	// MaxRetries is how many times a request to the API that failed for a
+	// temporary reason is retried.
+	MaxRetries *int `json:"max_retries,omitempty"`
+	// GitBranch is the branch git_commit commits to, created when needed.
+	GitBranch string `json:"git_branch,omitempty"`
+	// ToolTimeouts overrides how many seconds calls to a tool may run, by tool
+	// name. It is set in the config file only.
+	ToolTimeouts map[string]int `json:"tool_timeouts,omitempty"`
+
+	// projectFile is the project's config file when the settings came from it
+	// rather than from a file the user picked, so they may have come with the
+	// repository.
+	projectFile string
+}
+
+func DefaultConfig() Config {
+	compactThreshold := int64(150000)
+	diffMaxBytes := 256 * 1024
+	maxRetries := 5
+	postEditGo := true
+	return Config{
+		Provider:         providerAnthropic,
+		Model:            string(anthropic.ModelClaude3_7SonnetLatest),
+		MaxTokens:        4096,
+		CompactThreshold: &compactThreshold,
+		CommandTimeout:   120,
+		WorkspaceRoot:    ".",
+		DiffMaxBytes:     &diffMaxBytes,
+		PostEditGo:       &postEditGo,
+		MaxRetries:       &maxRetries,
+		GitBranch:        "agent",
+	}
+}
+
+// configFlags holds the command line flags that feed into Config.
+type configFlags struct {
+	fs               *flag.FlagSet
+	configPath       *string
+	provider         *string
+	baseURL          *string
+	model            *string
+	systemPrompt     *string
+	systemPromptFile *string
+	temperature      *float64
+	maxTokens        *int64
+	stopSequences    *string
+	compactThreshold *int64
+	commandTimeout   *int
+	workspaceRoot    *string
+	allowedDirs      *string
+	diffMaxBytes     *int
+	postEditGo       *bool
+	maxRetries       *int
+	gitBranch        *string
+}
+
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	return &configFlags{
+		fs:               fs,
+		configPath:       fs.String("config", "", "path to a JSON config file (default "+filepath.Join(projectDir, "config.json")+")"),
+		provider:         fs.String("provider", "", "API to use: anthropic, or openai for OpenAI-compatible servers such as llama.cpp or Ollama (default \"anthropic\")"),
+		baseURL:          fs.String("base-url", "", "base URL of the provider's API (default "+defaultOpenAIBaseURL+" for openai)"),
+		model:            fs.String("model", "", "model ID to use"),
+		systemPrompt:     fs.String("system", "", "system prompt to send with every request"),
+		systemPromptFile: fs.String("system-file", "", "file to read the system prompt from"),
+		temperature:      fs.Float64("temperature", 0, "sampling temperature"),
+		maxTokens:        fs.Int64("max-tokens", 0, "maximum number of tokens Claude may generate per response"),
+		stopSequences:    fs.String("stop", "", "comma-separated list of stop sequences"),
+		commandTimeout:   fs.Int("command-timeout", 0, "default timeout in seconds for commands run by the run_command tool"),
+		workspaceRoot:    fs.String("workspace", "", "directory the file tools are confined to (default the working directory)"),
+		allowedDirs:      fs.String("allow-dirs", "", "comma-separated list of extra directories the file tools may access"),
+		diffMaxBytes:     fs.Int("diff-max-bytes", 0, "largest file size in bytes that edits show a diff for (0 turns diffs off)"),
+		compactThreshold: fs.Int64("compact-threshold", 0, "context size in tokens that triggers automatic compaction (0 disables it)"),
+		postEditGo:       fs.Bool("post-edit-go", true, "format, fix the imports of and type-check Go files after the tools change them"),
+		maxRetries:       fs.Int("max-retries", 0, "how many times to retry API requests that fail for a temporary reason"),
+		gitBranch:        fs.String("git-branch", "", "branch the git_commit tool commits to (default \"agent\")"),
+	}
+}
+
+// LoadConfig builds the agent configuration from the defaults, the config file,
+// the environment and the already parsed command line flags.
+func LoadConfig(flags *configFlags) (Config, error) {
+	config := DefaultConfig()
+
+	configPath := os.Getenv("AGENT_CONFIG")
+	if *flags.configPath != "" {
+		configPath = *flags.configPath
+	}
+	err := config.loadFile(configPath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = config.loadEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	flags.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "provider":
+			config.Provider = *flags.provider
+		case "base-url":
+			config.BaseURL = *flags.baseURL
+		case "model":
+			config.Model = *flags.model
+		case "system":
+			config.setSystemPrompt(*flags.systemPrompt)
+		case "system-file":
+			config.setSystemPromptFile(*flags.systemPromptFile)
+		case "temperature":
+			config.Temperature = flags.temperature
+		case "max-tokens":
+			config.MaxTokens = *flags.maxTokens
+		case "stop":
+			config.StopSequences = splitList(*flags.stopSequences)
+		case "compact-threshold":
+			config.CompactThreshold = flags.compactThreshold
+		case "command-timeout":
+			config.CommandTimeout = *flags.commandTimeout
+		case "workspace":
+			config.WorkspaceRoot = *flags.workspaceRoot
+		case "allow-dirs":
+			config.AllowedDirs = splitList(*flags.allowedDirs)
+		case "diff-max-bytes":
+			config.DiffMaxBytes = flags.diffMaxBytes
+		case "post-edit-go":
+			config.PostEditGo = flags.postEditGo
+		case "max-retries":
+			config.MaxRetries = flags.maxRetries
+		case "git-branch":
+			config.GitBranch = *flags.gitBranch
+		}
+	})
+
+	if config.SystemPromptFile != "" {
+		content, err := os.ReadFile(config.SystemPromptFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read system prompt file: %w", err)
+		}
+		config.SystemPrompt = string(content)
+	}
+
+	if config.Provider != providerAnthropic && config.Provider != providerOpenAI {
+		return Config{}, fmt.Errorf("provider must be %s or %s, got %q", providerAnthropic, providerOpenAI, config.Provider)
+	}
+	if config.Model == "" {
+		return Config{}, fmt.Errorf("model must not be empty")
+	}
+	if config.MaxTokens < 1 {
+		return Config{}, fmt.Errorf("max tokens must be positive, got %d", config.MaxTokens)
+	}
+	if *config.CompactThreshold < 0 {
+		return Config{}, fmt.Errorf("compact threshold must not be negative, got %d", *config.CompactThreshold)
+	}
+	if config.CommandTimeout < 1 {
+		return Config{}, fmt.Errorf("command timeout must be positive, got %d", config.CommandTimeout)
+	}
+	if *config.DiffMaxBytes < 0 {
+		return Config{}, fmt.Errorf("diff max bytes must not be negative, got %d", *config.DiffMaxBytes)
+	}
+	// OpenAI-compatible servers take temperatures up to 2, Anthropic's API up to 1
+	maxTemperature := 1.0
+	if config.Provider == providerOpenAI {
+		maxTemperature = 2
+	}
+	if config.Temperature != nil && (*config.Temperature < 0 || *config.Temperature > maxTemperature) {
+		return Config{}, fmt.Errorf("temperature must be between 0 and %g for %s, got %g", maxTemperature, config.Provider, *config.Temperature)
+	}
+	if *config.MaxRetries < 0 {
+		return Config{}, fmt.Errorf("max retries must not be negative, got %d", *config.MaxRetries)
+	}
+	if config.GitBranch == "" || strings.HasPrefix(config.GitBranch, "-") {
+		return Config{}, fmt.Errorf("invalid git branch %q", config.GitBranch)
+	}
+	for tool, seconds := range config.ToolTimeouts {
+		if seconds < 1 {
+			return Config{}, fmt.Errorf("timeout of %s must be positive, got %d", tool, seconds)
+		}
+	}
+	for _, hook := range config.PostEditHooks {
+		if hook.Pattern == "" || strings.TrimSpace(hook.Command) == "" {
+			return Config{}, fmt.Errorf("post-edit hooks need a pattern and a command")
+		}
+	}
+
+	return config, nil
+}
+
+// loadFile overlays the settings in the JSON config file at path. An empty path
+// means the default project config file, which is optional.
+func (c *Config) loadFile(path string) error {
+	optional := path == ""
+	if optional {
+		path = filepath.Join(projectDir, "config.json")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	fileConfig := Config{}
+	err = json.Unmarshal(content, &fileConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if optional {
+		c.projectFile = path
+	}
+
+	if fileConfig.Provider != "" {
+		c.Provider = fileConfig.Provider
+	}
+	if fileConfig.BaseURL != "" {
+		c.BaseURL = fileConfig.BaseURL
+	}
+	if fileConfig.Model != "" {
+		c.Model = fileConfig.Model
+	}
+	if fileConfig.SystemPrompt != "" {
+		c.setSystemPrompt(fileConfig.SystemPrompt)
+	}
+	if fileConfig.SystemPromptFile != "" {
+		// relative prompt files are resolved against the directory of the config file
+		promptFile := fileConfig.SystemPromptFile
+		if !filepath.IsAbs(promptFile) {
+			promptFile = filepath.Join(filepath.Dir(path), promptFile)
+		}
+		c.setSystemPromptFile(promptFile)
+	}
+	if fileConfig.Temperature != nil {
+		c.Temperature = fileConfig.Temperature
+	}
+	if fileConfig.MaxTokens != 0 {
+		c.MaxTokens = fileConfig.MaxTokens
+	}
+	if fileConfig.StopSequences != nil {
+		c.StopSequences = fileConfig.StopSequences
+	}
+	if fileConfig.CompactThreshold != nil {
+		c.CompactThreshold = fileConfig.CompactThreshold
+	}
+	if fileConfig.CommandTimeout != 0 {
+		c.CommandTimeout = fileConfig.CommandTimeout
+	}
+	if fileConfig.WorkspaceRoot != "" {
+		c.WorkspaceRoot = fileConfig.WorkspaceRoot
+	}
+	if fileConfig.AllowedDirs != nil {
+		c.AllowedDirs = fileConfig.AllowedDirs
+	}
+	if fileConfig.DiffMaxBytes != nil {
+		c.DiffMaxBytes = fileConfig.DiffMaxBytes
+	}
+	if fileConfig.PostEditGo != nil {
+		c.PostEditGo = fileConfig.PostEditGo
+	}
+	if fileConfig.PostEditHooks != nil {
+		c.PostEditHooks = fileConfig.PostEditHooks
+	}
+	if fileConfig.MaxRetries != nil {
+		c.MaxRetries = fileConfig.MaxRetries
+	}
+	if fileConfig.ToolTimeouts != nil {
+		c.ToolTimeouts = fileConfig.ToolTimeouts
+	}
+	if fileConfig.GitBranch != "" {
+		c.GitBranch = fileConfig.GitBranch
+	}
+
+	return nil
+}
+
+// loadEnv overlays the settings from AGENT_* environment variables.
+func (c *Config) loadEnv() error {
+	if v := os.Getenv("AGENT_PROVIDER"); v != "" {
+		c.Provider = v
+	}
+	if v := os.Getenv("AGENT_BASE_URL"); v != "" {
+		c.BaseURL = v
+	}
+	if v := os.Getenv("AGENT_MODEL"); v != "" {
+		c.Model = v
+	}
+	if v := os.Getenv("AGENT_SYSTEM_PROMPT"); v != "" {
+		c.setSystemPrompt(v)
+	}
+	if v := os.Getenv("AGENT_SYSTEM_PROMPT_FILE"); v != "" {
+		c.setSystemPromptFile(v)
+	}
+	if v := os.Getenv("AGENT_TEMPERATURE"); v != "" {
+		temperature, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_TEMPERATURE: %w", err)
+		}
+		c.Temperature = &temperature
+	}
+	if v := os.Getenv("AGENT_MAX_TOKENS"); v != "" {
+		maxTokens, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_MAX_TOKENS: %w", err)
+		}
+		c.MaxTokens = maxTokens
+	}
+	if v := os.Getenv("AGENT_STOP_SEQUENCES"); v != "" {
+		c.StopSequences = splitList(v)
+	}
+	if v := os.Getenv("AGENT_COMPACT_THRESHOLD"); v != "" {
+		compactThreshold, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_COMPACT_THRESHOLD: %w", err)
+		}
+		c.CompactThreshold = &compactThreshold
+	}
+	if v := os.Getenv("AGENT_COMMAND_TIMEOUT"); v != "" {
+		commandTimeout, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_COMMAND_TIMEOUT: %w", err)
+		}
+		c.CommandTimeout = commandTimeout
+	}
+	if v := os.Getenv("AGENT_WORKSPACE"); v != "" {
+		c.WorkspaceRoot = v
+	}
+	if v := os.Getenv("AGENT_ALLOWED_DIRS"); v != "" {
+		c.AllowedDirs = splitList(v)
+	}
+	if v := os.Getenv("AGENT_DIFF_MAX_BYTES"); v != "" {
+		diffMaxBytes, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_DIFF_MAX_BYTES: %w", err)
+		}
+		c.DiffMaxBytes = &diffMaxBytes
+	}
+	if v := os.Getenv("AGENT_POST_EDIT_GO"); v != "" {
+		postEditGo, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_POST_EDIT_GO: %w", err)
+		}
+		c.PostEditGo = &postEditGo
+	}
+	if v := os.Getenv("AGENT_MAX_RETRIES"); v != "" {
+		maxRetries, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_MAX_RETRIES: %w", err)
+		}
+		c.MaxRetries = &maxRetries
+	}
+	if v := os.Getenv("AGENT_GIT_BRANCH"); v != "" {
+		c.GitBranch = v
+	}
+	return nil
+}
+
+// setSystemPrompt and setSystemPromptFile make the most recently applied
+// source win when one layer sets an inline prompt and another sets a file.
+func (c *Config) setSystemPrompt(prompt string) {
+	c.SystemPrompt = prompt
+	c.SystemPromptFile = ""
+}
+
+func (c *Config) setSystemPromptFile(path string) {
+	c.SystemPromptFile = path
+	c.SystemPrompt = ""
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
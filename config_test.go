@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	// create a config file and a system prompt file next to it
+	os.WriteFile("test_config.json", []byte(`{
+		"model": "file-model",
+		"system_prompt_file": "test_prompt.txt",
+		"max_tokens": 2048,
+		"stop_sequences": ["END"]
+	}`), 0644)
+	defer os.Remove("test_config.json")
+	os.WriteFile("test_prompt.txt", []byte("follow the house rules"), 0644)
+	defer os.Remove("test_prompt.txt")
+
+	// happy path: the file overrides the defaults
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	err := fs.Parse([]string{"-config", "test_config.json"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	config, err := LoadConfig(flags)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if config.Model != "file-model" || config.MaxTokens != 2048 || config.SystemPrompt != "follow the house rules" {
+		t.Fatalf("expected settings from the config file, got %+v", config)
+	}
+	if !reflect.DeepEqual(config.StopSequences, []string{"END"}) {
+		t.Fatalf("expected [END], got %v", config.StopSequences)
+	}
+
+	// test env vars override the file and flags override env vars
+	t.Setenv("AGENT_MODEL", "env-model")
+	t.Setenv("AGENT_MAX_TOKENS", "512")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags = registerConfigFlags(fs)
+	err = fs.Parse([]string{"-config", "test_config.json", "-max-tokens", "100", "-system", "inline prompt", "-temperature", "0.5"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	config, err = LoadConfig(flags)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if config.Model != "env-model" {
+		t.Fatalf("expected env-model, got %s", config.Model)
+	}
+	if config.MaxTokens != 100 {
+		t.Fatalf("expected 100, got %d", config.MaxTokens)
+	}
+	if config.SystemPrompt != "inline prompt" {
+		t.Fatalf("expected inline prompt, got %s", config.SystemPrompt)
+	}
+	if config.Temperature == nil || *config.Temperature != 0.5 {
+		t.Fatalf("expected temperature 0.5, got %v", config.Temperature)
+	}
+	if config.projectFile != "" {
+		t.Fatalf("expected a config file the user picked not to count as the project's, got %s", config.projectFile)
+	}
+
+	// test invalid temperature
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags = registerConfigFlags(fs)
+	err = fs.Parse([]string{"-config", "test_config.json", "-temperature", "2"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	_, err = LoadConfig(flags)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test OpenAI-compatible servers take temperatures up to 2
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags = registerConfigFlags(fs)
+	err = fs.Parse([]string{"-config", "test_config.json", "-provider", "openai", "-temperature", "2"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	config, err = LoadConfig(flags)
+	if err != nil || *config.Temperature != 2 {
+		t.Fatalf("expected temperature 2 for openai, got %v", err)
+	}
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags = registerConfigFlags(fs)
+	err = fs.Parse([]string{"-config", "test_config.json", "-provider", "openai", "-temperature", "2.5"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	_, err = LoadConfig(flags)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test missing config file
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags = registerConfigFlags(fs)
+	err = fs.Parse([]string{"-config", "missing_config.json"})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	_, err = LoadConfig(flags)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestConfigFileZeroValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"compact_threshold": 0, "diff_max_bytes": 0, "max_retries": 0}`), 0644)
+
+	// happy path: zero in the config file turns a setting off rather than being ignored
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	err := fs.Parse([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	config, err := LoadConfig(flags)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if *config.CompactThreshold != 0 {
+		t.Fatalf("expected compaction to be off, got %d", *config.CompactThreshold)
+	}
+	if *config.DiffMaxBytes != 0 {
+		t.Fatalf("expected diffs to be off, got %d", *config.DiffMaxBytes)
+	}
+	if *config.MaxRetries != 0 {
+		t.Fatalf("expected retries to be off, got %d", *config.MaxRetries)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// diffMaxBytes is the largest file size edits show a diff for. Zero turns diffs off.
+	diffMaxBytes = 256 * 1024
+	// diffContextLines is how much unchanged context the terminal diff shows around
+	// each change; the copy returned to the model uses diffCompactContextLines.
+	diffContextLines        = 3
+	diffCompactContextLines = 1
+)
+
+// diffOp is one line of a line-based diff: ' ' for unchanged, '-' for removed and '+' for added.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// maxDiffCells bounds the size of the LCS table. Past it the changed region is
+// shown as a plain removal followed by an addition instead.
+const maxDiffCells = 4_000_000
+
+// diffLines computes a line diff between a and b. The common prefix and suffix
+// are matched directly, so only the region that changed goes through the LCS table.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []diffOp{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	midA := a[prefix : len(a)-suffix]
+	midB := b[prefix : len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range midB {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				ops = append(ops, diffOp{' ', midA[i]})
+				i++
+				j++
+			case i < len(midA) && (j == len(midB) || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', midA[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', midB[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// unifiedDiff returns a unified diff between two versions of a file with the
+// given number of context lines, or "" if they are the same.
+func unifiedDiff(name, oldContent, newContent string, contextLines int) string {
+	ops := diffLines(splitLinesKeepEnds(oldContent), splitLinesKeepEnds(newContent))
+
+	// oldLines[i] and newLines[i] count the lines of each version before ops[i]
+	oldLines := make([]int, len(ops)+1)
+	newLines := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLines[i+1] = oldLines[i]
+		newLines[i+1] = newLines[i]
+		if op.kind != '+' {
+			oldLines[i+1]++
+		}
+		if op.kind != '-' {
+			newLines[i+1]++
+		}
+	}
+
+	diff := strings.Builder{}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// grow the hunk while the next change is close enough for the contexts to touch
+		start := max(0, i-contextLines)
+		last := i
+		for j := i; j < len(ops) && j-last <= 2*contextLines+1; j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			}
+		}
+		end := min(len(ops), last+contextLines+1)
+
+		if diff.Len() == 0 {
+			fmt.Fprintf(&diff, "--- a/%s\n+++ b/%s\n", name, name)
+		}
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n",
+			hunkRange(oldLines[start], oldLines[end]-oldLines[start]),
+			hunkRange(newLines[start], newLines[end]-newLines[start]))
+		for _, op := range ops[start:end] {
+			diff.WriteByte(op.kind)
+			diff.WriteString(strings.TrimSuffix(op.line, "\n"))
+			diff.WriteByte('\n')
+			if !strings.HasSuffix(op.line, "\n") {
+				diff.WriteString("\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return diff.String()
+}
+
+func hunkRange(before, length int) string {
+	// an empty range points at the line before it, anything else at its first line
+	if length == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, length)
+}
+
+func splitLinesKeepEnds(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// colorDiff adds terminal colors to a unified diff.
+func colorDiff(diff string) string {
+	colored := strings.Builder{}
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "--- a/"), strings.HasPrefix(line, "+++ b/"):
+			colored.WriteString("\u001b[1m" + strings.TrimSuffix(line, "\n") + "\u001b[0m\n")
+		case strings.HasPrefix(line, "@@"):
+			colored.WriteString("\u001b[96m" + strings.TrimSuffix(line, "\n") + "\u001b[0m\n")
+		case strings.HasPrefix(line, "-"):
+			colored.WriteString("\u001b[91m" + strings.TrimSuffix(line, "\n") + "\u001b[0m\n")
+		case strings.HasPrefix(line, "+"):
+			colored.WriteString("\u001b[92m" + strings.TrimSuffix(line, "\n") + "\u001b[0m\n")
+		default:
+			colored.WriteString(line)
+		}
+	}
+	return colored.String()
+}
+
+// reportEdit builds the tool result for a file edit. It prints a colored diff
+// for the user and returns "OK" followed by a compact diff for the model.
+func reportEdit(path, oldContent, newContent string) string {
+	if diffMaxBytes == 0 {
+		return "OK"
+	}
+	if len(oldContent) > diffMaxBytes || len(newContent) > diffMaxBytes {
+		return fmt.Sprintf("OK (diff omitted because the file is larger than %d bytes)", diffMaxBytes)
+	}
+
+	name := workspace.Rel(path)
+	fmt.Print(colorDiff(unifiedDiff(name, oldContent, newContent, diffContextLines)))
+	return "OK\n" + unifiedDiff(name, oldContent, newContent, diffCompactContextLines)
+}
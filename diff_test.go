@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	oldContent := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newContent := "a\nB\nc\nd\ne\nf\ng\nh\nI\nj\n"
+
+	// happy path: changes far apart get separate hunks
+	diff := unifiedDiff("file.txt", oldContent, newContent, 1)
+	expected := "--- a/file.txt\n+++ b/file.txt\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n@@ -8,3 +8,3 @@\n h\n-i\n+I\n j\n"
+	if diff != expected {
+		t.Fatalf("expected %q, got %q", expected, diff)
+	}
+
+	// test changes close together share a hunk
+	diff = unifiedDiff("file.txt", oldContent, newContent, 3)
+	if strings.Count(diff, "@@ -") != 1 {
+		t.Fatalf("expected one hunk, got %q", diff)
+	}
+
+	// test a missing newline at the end of the file is marked
+	diff = unifiedDiff("file.txt", "a\nb", "a\nc", 1)
+	expected = "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"
+	if diff != expected {
+		t.Fatalf("expected %q, got %q", expected, diff)
+	}
+
+	// test creating a file from nothing
+	diff = unifiedDiff("file.txt", "", "a\n", 1)
+	expected = "--- a/file.txt\n+++ b/file.txt\n@@ -0,0 +1 @@\n+a\n"
+	if diff != expected {
+		t.Fatalf("expected %q, got %q", expected, diff)
+	}
+
+	// test identical content has no diff
+	if unifiedDiff("file.txt", oldContent, oldContent, 1) != "" {
+		t.Fatalf("expected no diff")
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gitBranch is the only branch git_commit commits to.
+var gitBranch = "agent"
+
+// defaultGitLogCount is how many commits git_log shows when the model doesn't
+// ask for a different number.
+const defaultGitLogCount = 20
+
+var GitStatusDefinition = ToolDefinition{
+	Name:        "git_status",
+	Description: "Show the current git branch and the files that are changed, staged or untracked in the workspace's repository.",
+	InputSchema: GitStatusInputSchema,
+	Function:    GitStatus,
+}
+
+type GitStatusInput struct{}
+
+var GitStatusInputSchema = GenerateSchema[GitStatusInput]()
+
+func GitStatus(ctx context.Context, input json.RawMessage) (string, error) {
+	output, err := runGit(ctx, "status", "--short", "--branch")
+	if err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+var GitDiffDefinition = ToolDefinition{
+	Name: "git_diff",
+	Description: `Show changes in the workspace's git repository as a unified diff.
+
+By default shows the unstaged changes in the working tree. Set 'staged' to see the changes staged for the next commit instead, or give 'from' (and optionally 'to') to compare commits, branches or tags, e.g. from "main" or from "HEAD~3" to "HEAD". 'paths' limits the diff to some files or directories.
+`,
+	InputSchema: GitDiffInputSchema,
+	Function:    GitDiff,
+}
+
+type GitDiffInput struct {
+	Staged bool     `json:"staged,omitempty" jsonschema_description:"Show the staged changes instead of the unstaged ones"`
+	From   string   `json:"from,omitempty" jsonschema_description:"Optional ref to compare from, e.g. main or HEAD~1"`
+	To     string   `json:"to,omitempty" jsonschema_description:"Optional ref to compare to. Defaults to the working tree."`
+	Paths  []string `json:"paths,omitempty" jsonschema_description:"Optional files or directories to limit the diff to"`
+}
+
+var GitDiffInputSchema = GenerateSchema[GitDiffInput]()
+
+func GitDiff(ctx context.Context, input json.RawMessage) (string, error) {
+	gitDiffInput := GitDiffInput{}
+	err := json.Unmarshal(input, &gitDiffInput)
+	if err != nil {
+		return "", err
+	}
+
+	if gitDiffInput.To != "" && gitDiffInput.From == "" {
+		return "", fmt.Errorf("'to' needs 'from'")
+	}
+	args := []string{"diff"}
+	if gitDiffInput.Staged {
+		args = append(args, "--cached")
+	}
+	for _, ref := range []string{gitDiffInput.From, gitDiffInput.To} {
+		if ref == "" {
+			continue
+		}
+		err = checkGitRef(ref)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, ref)
+	}
+	paths, err := gitPaths(gitDiffInput.Paths)
+	if err != nil {
+		return "", err
+	}
+	args = append(append(args, "--"), paths...)
+
+	output, err := runGit(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	if output == "" {
+		return "No changes", nil
+	}
+	return truncateOutput(output, maxCommandOutput), nil
+}
+
+var GitLogDefinition = ToolDefinition{
+	Name: "git_log",
+	Description: `Show the commit history of the workspace's git repository, newest first.
+
+Each commit is shown on one line with its short hash, date, author and subject. Give 'path' to only show the commits that changed a file or directory, and 'ref' to show the history of another branch.
+`,
+	InputSchema: GitLogInputSchema,
+	Function:    GitLog,
+}
+
+type GitLogInput struct {
+	Path     string `json:"path,omitempty" jsonschema_description:"Optional file or directory to show the history of"`
+	Ref      string `json:"ref,omitempty" jsonschema_description:"Optional branch, tag or commit to start from. Defaults to HEAD."`
+	MaxCount int    `json:"max_count,omitempty" jsonschema_description:"Maximum number of commits to show. Defaults to 20."`
+}
+
+var GitLogInputSchema = GenerateSchema[GitLogInput]()
+
+func GitLog(ctx context.Context, input json.RawMessage) (string, error) {
+	gitLogInput := GitLogInput{}
+	err := json.Unmarshal(input, &gitLogInput)
+	if err != nil {
+		return "", err
+	}
+
+	if gitLogInput.MaxCount < 0 {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	maxCount := gitLogInput.MaxCount
+	if maxCount == 0 {
+		maxCount = defaultGitLogCount
+	}
+
+	args := []string{"log", "--max-count=" + strconv.Itoa(maxCount), "--date=short", "--format=%h %ad %an: %s"}
+	if gitLogInput.Ref != "" {
+		err = checkGitRef(gitLogInput.Ref)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, gitLogInput.Ref)
+	}
+	args = append(args, "--")
+	if gitLogInput.Path != "" {
+		paths, err := gitPaths([]string{gitLogInput.Path})
+		if err != nil {
+			return "", err
+		}
+		args = append(args, paths...)
+	}
+
+	output, err := runGit(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	if output == "" {
+		return "No commits", nil
+	}
+	return output, nil
+}
+
+var GitBlameDefinition = ToolDefinition{
+	Name: "git_blame",
+	Description: `Show who last changed each line in a range of a file, and in which commit.
+
+'start_line' and 'end_line' are 1-indexed and inclusive.
+`,
+	InputSchema: GitBlameInputSchema,
+	Function:    GitBlame,
+}
+
+type GitBlameInput struct {
+	Path      string `json:"path" jsonschema_description:"The file to blame"`
+	StartLine int    `json:"start_line" jsonschema_description:"The first line to blame"`
+	EndLine   int    `json:"end_line" jsonschema_description:"The last line to blame"`
+}
+
+var GitBlameInputSchema = GenerateSchema[GitBlameInput]()
+
+func GitBlame(ctx context.Context, input json.RawMessage) (string, error) {
+	gitBlameInput := GitBlameInput{}
+	err := json.Unmarshal(input, &gitBlameInput)
+	if err != nil {
+		return "", err
+	}
+
+	if gitBlameInput.Path == "" || gitBlameInput.StartLine < 1 || gitBlameInput.EndLine < gitBlameInput.StartLine {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	paths, err := gitPaths([]string{gitBlameInput.Path})
+	if err != nil {
+		return "", err
+	}
+
+	lines := fmt.Sprintf("%d,%d", gitBlameInput.StartLine, gitBlameInput.EndLine)
+	output, err := runGit(ctx, "blame", "--date=short", "-L", lines, "--", paths[0])
+	if err != nil {
+		return "", err
+	}
+	return truncateOutput(output, maxCommandOutput), nil
+}
+
+var GitCommitDefinition = ToolDefinition{
+	Name: "git_commit",
+	Description: `Commit changes in the workspace's git repository with the given message.
+
+Commits only go to the agent's own branch. If the repository is on another branch, the agent branch is created from the current commit and switched to first, keeping the uncommitted changes. Nothing is pushed.
+
+Commits all changes, including new and deleted files, unless 'paths' limits it to some files or directories. Write the message like a good commit message: a short summary line, a blank line, then what changed and why.
+
+The user must approve commits.
+`,
+	InputSchema: GitCommitInputSchema,
+	Function:    GitCommit,
+	Mutating:    true,
+}
+
+type GitCommitInput struct {
+	Message string   `json:"message" jsonschema_description:"The commit message"`
+	Paths   []string `json:"paths,omitempty" jsonschema_description:"Optional files or directories to commit. Defaults to all changes."`
+}
+
+var GitCommitInputSchema = GenerateSchema[GitCommitInput]()
+
+func GitCommit(ctx context.Context, input json.RawMessage) (string, error) {
+	gitCommitInput := GitCommitInput{}
+	err := json.Unmarshal(input, &gitCommitInput)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(gitCommitInput.Message) == "" {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	paths, err := gitPaths(gitCommitInput.Paths)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := runGit(ctx, "branch", "--show-current")
+	if err != nil {
+		return "", err
+	}
+	current := strings.TrimSpace(output)
+	switched := ""
+	if current != gitBranch {
+		_, err = runGit(ctx, "rev-parse", "--verify", "--quiet", "refs/heads/"+gitBranch)
+		if err == nil {
+			return "", fmt.Errorf("the repository is on %q, but the agent branch %q already exists; ask the user to switch to it or delete it", current, gitBranch)
+		}
+		_, err = runGit(ctx, "switch", "--create", gitBranch)
+		if err != nil {
+			return "", err
+		}
+		switched = fmt.Sprintf("Created branch %s from %s and switched to it\n", gitBranch, current)
+	}
+
+	if len(paths) == 0 {
+		paths = []string{workspace.Root()}
+	}
+	_, err = runGit(ctx, append([]string{"add", "--all", "--"}, paths...)...)
+	if err != nil {
+		return "", err
+	}
+	_, err = runGit(ctx, append([]string{"diff", "--cached", "--quiet", "--"}, paths...)...)
+	if err == nil {
+		return switched + "Nothing to commit", nil
+	}
+
+	// --only leaves out anything the user staged outside the paths
+	_, err = runGitWithInput(ctx, gitCommitInput.Message, append([]string{"commit", "--quiet", "--file=-", "--only", "--"}, paths...)...)
+	if err != nil {
+		return "", err
+	}
+	output, err = runGit(ctx, "log", "--max-count=1", "--stat", "--format=%h %s")
+	if err != nil {
+		return "", err
+	}
+	return switched + "Committed " + output, nil
+}
+
+// runGit runs git in the workspace root and returns its stdout.
+func runGit(ctx context.Context, args ...string) (string, error) {
+	return runGitWithInput(ctx, "", args...)
+}
+
+func runGitWithInput(ctx context.Context, stdin string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = workspace.Root()
+	// read-only commands shouldn't take the index lock, and nothing should prompt
+	cmd.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0", "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// gitPaths resolves paths given to a git tool with the same rules as the file tools.
+func gitPaths(paths []string) ([]string, error) {
+	resolved := []string{}
+	for _, path := range paths {
+		p, err := workspace.Resolve(path)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
+// checkGitRef rejects refs that git would take as options.
+func checkGitRef(ref string) error {
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid ref %q", ref)
+	}
+	return nil
+}
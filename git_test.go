@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGitRepo makes a temporary workspace holding a git repository on main
+// with one commit of a.txt.
+func newGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Tester")
+	t.Setenv("GIT_AUTHOR_EMAIL", "tester@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Tester")
+	t.Setenv("GIT_COMMITTER_EMAIL", "tester@example.com")
+
+	root := t.TempDir()
+	w, err := NewWorkspace(root, nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	previous := workspace
+	workspace = w
+	t.Cleanup(func() { workspace = previous })
+
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\ntwo\n"), 0644)
+	for _, args := range [][]string{{"init", "--quiet", "--initial-branch=main"}, {"add", "a.txt"}, {"commit", "--quiet", "-m", "Add a.txt"}} {
+		_, err = runGit(context.Background(), args...)
+		if err != nil {
+			t.Fatalf("failed to set up the repository: %v", err)
+		}
+	}
+	return root
+}
+
+func TestGitReadTools(t *testing.T) {
+	root := newGitRepo(t)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\n2\n"), 0644)
+	os.WriteFile(filepath.Join(root, "b.txt"), []byte("new\n"), 0644)
+
+	// happy path: status shows the branch, changed and untracked files
+	result, err := GitStatus(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if result != "## main\n M a.txt\n?? b.txt\n" {
+		t.Fatalf("unexpected status: %q", result)
+	}
+
+	// test the working tree diff, and that nothing is staged
+	result, err = GitDiff(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("failed to get diff: %v", err)
+	}
+	if !strings.Contains(result, "-two\n+2\n") {
+		t.Fatalf("unexpected diff: %q", result)
+	}
+	result, err = GitDiff(context.Background(), json.RawMessage(`{"staged": true}`))
+	if err != nil {
+		t.Fatalf("failed to get staged diff: %v", err)
+	}
+	if result != "No changes" {
+		t.Fatalf("expected no changes, got %q", result)
+	}
+
+	// test the diff between refs and the path filter of the log
+	_, err = runGit(context.Background(), "commit", "--quiet", "-am", "Change a.txt")
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	result, err = GitDiff(context.Background(), json.RawMessage(`{"from": "HEAD~1", "to": "HEAD", "paths": ["a.txt"]}`))
+	if err != nil {
+		t.Fatalf("failed to diff refs: %v", err)
+	}
+	if !strings.Contains(result, "+2\n") {
+		t.Fatalf("unexpected diff: %q", result)
+	}
+	result, err = GitLog(context.Background(), json.RawMessage(`{"path": "a.txt"}`))
+	if err != nil {
+		t.Fatalf("failed to get log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(result), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " Tester: Change a.txt") || !strings.HasSuffix(lines[1], " Tester: Add a.txt") {
+		t.Fatalf("unexpected log: %q", result)
+	}
+	result, err = GitLog(context.Background(), json.RawMessage(`{"path": "b.txt"}`))
+	if err != nil {
+		t.Fatalf("failed to get log: %v", err)
+	}
+	if result != "No commits" {
+		t.Fatalf("expected no commits, got %q", result)
+	}
+
+	// test blaming a line range
+	result, err = GitBlame(context.Background(), json.RawMessage(`{"path": "a.txt", "start_line": 2, "end_line": 2}`))
+	if err != nil {
+		t.Fatalf("failed to blame: %v", err)
+	}
+	if strings.Count(result, "\n") != 1 || !strings.Contains(result, "(Tester ") || !strings.HasSuffix(result, ") 2\n") {
+		t.Fatalf("unexpected blame: %q", result)
+	}
+
+	// test invalid input
+	for _, input := range []string{
+		`{"from": "--output=x"}`,
+		`{"to": "HEAD"}`,
+		`{"paths": ["../outside"]}`,
+	} {
+		_, err = GitDiff(context.Background(), json.RawMessage(input))
+		if err == nil {
+			t.Fatalf("expected error for %s, got nil", input)
+		}
+	}
+	_, err = GitBlame(context.Background(), json.RawMessage(`{"path": "a.txt", "start_line": 2, "end_line": 1}`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestGitCommit(t *testing.T) {
+	root := newGitRepo(t)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\n2\n"), 0644)
+	os.WriteFile(filepath.Join(root, "b.txt"), []byte("new\n"), 0644)
+	_, err := runGit(context.Background(), "add", "a.txt")
+	if err != nil {
+		t.Fatalf("failed to stage: %v", err)
+	}
+
+	// happy path: the agent branch is created and only the given path committed, leaving what the user staged
+	result, err := GitCommit(context.Background(), json.RawMessage(`{"message": "Add b.txt\n\nIt is new.", "paths": ["b.txt"]}`))
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if !strings.HasPrefix(result, "Created branch agent from main and switched to it\nCommitted ") || !strings.Contains(result, " Add b.txt\n") {
+		t.Fatalf("unexpected result: %q", result)
+	}
+	status, _ := runGit(context.Background(), "status", "--short", "--branch")
+	if status != "## agent\nM  a.txt\n" {
+		t.Fatalf("unexpected status: %q", status)
+	}
+	message, _ := runGit(context.Background(), "log", "--max-count=1", "--format=%B")
+	if strings.TrimSpace(message) != "Add b.txt\n\nIt is new." {
+		t.Fatalf("unexpected message: %q", message)
+	}
+
+	// test committing everything on the existing agent branch
+	result, err = GitCommit(context.Background(), json.RawMessage(`{"message": "Change a.txt"}`))
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if !strings.HasPrefix(result, "Committed ") {
+		t.Fatalf("unexpected result: %q", result)
+	}
+	result, err = GitCommit(context.Background(), json.RawMessage(`{"message": "Nothing"}`))
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if result != "Nothing to commit" {
+		t.Fatalf("expected nothing to commit, got %q", result)
+	}
+
+	// test that it won't commit from another branch while the agent branch exists
+	_, err = runGit(context.Background(), "switch", "--quiet", "main")
+	if err != nil {
+		t.Fatalf("failed to switch: %v", err)
+	}
+	os.WriteFile(filepath.Join(root, "c.txt"), []byte("c\n"), 0644)
+	_, err = GitCommit(context.Background(), json.RawMessage(`{"message": "Add c.txt"}`))
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an error about the existing branch, got %v", err)
+	}
+	log, _ := runGit(context.Background(), "log", "--format=%s", "main")
+	if log != "Add a.txt\n" {
+		t.Fatalf("expected main to be unchanged, got %q", log)
+	}
+
+	// test an empty message
+	_, err = GitCommit(context.Background(), json.RawMessage(`{"message": " "}`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is one pattern from a .gitignore file. base is the directory of
+// the file, relative to the matcher's root and slash-separated ("" for the root).
+type ignoreRule struct {
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreMatcher decides which paths under root the .gitignore files exclude.
+// It only knows the rules of the directories loaded so far, which is enough
+// for a walk that loads each directory before going into it.
+type ignoreMatcher struct {
+	root  string
+	rules []ignoreRule
+}
+
+// newIgnoreMatcher returns a matcher for walking dir. The .gitignore files of
+// the directories between the workspace root and dir are loaded up front; dir's
+// own and those below it are loaded by the walk.
+func newIgnoreMatcher(dir string) *ignoreMatcher {
+	root := workspace.Root()
+	if !isWithin(root, dir) {
+		root = dir
+	}
+	m := &ignoreMatcher{root: root}
+	for parent := root; parent != dir; {
+		m.load(parent)
+		rel, err := filepath.Rel(parent, dir)
+		if err != nil {
+			break
+		}
+		parent = filepath.Join(parent, strings.Split(rel, string(filepath.Separator))[0])
+	}
+	return m
+}
+
+// load adds the rules of the .gitignore file in dir, if there is one.
+func (m *ignoreMatcher) load(dir string) {
+	content, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	base := m.rel(dir)
+	if base == "." {
+		base = ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		rule, ok := parseIgnoreRule(base, line)
+		if ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.HasPrefix(line, "/") {
+		rule.anchored = true
+		line = line[1:]
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	// gitignore negates a character class with "!", path.Match with "^"
+	rule.pattern = strings.ReplaceAll(line, "[!", "[^")
+	return rule, true
+}
+
+// Ignored reports whether the path is excluded. As in git, the last rule that
+// matches wins.
+func (m *ignoreMatcher) Ignored(p string, isDir bool) bool {
+	rel := m.rel(p)
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.matches(rel, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.base+"/")
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	matched, err := path.Match(r.pattern, path.Base(rel))
+	return err == nil && matched
+}
+
+func (m *ignoreMatcher) rel(p string) string {
+	rel, err := filepath.Rel(m.root, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// walkFiles walks dir like filepath.WalkDir, but never goes into .git
+// directories, skips symlinks that are dangling or lead out of the workspace,
+// and, when respectGitignore is set, skips what the .gitignore files exclude.
+func walkFiles(dir string, respectGitignore bool, fn fs.WalkDirFunc) error {
+	var ignore *ignoreMatcher
+	if respectGitignore {
+		ignore = newIgnoreMatcher(dir)
+	}
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(p, d, err)
+		}
+		if p != dir {
+			if d.IsDir() && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if ignore != nil && ignore.Ignored(p, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 && !symlinkInWorkspace(p) {
+				return nil
+			}
+		}
+		if ignore != nil && d.IsDir() {
+			ignore.load(p)
+		}
+		return fn(p, d, nil)
+	})
+}
+
+// skipWalkError is what a walk callback returns for an entry it can't read:
+// the entry, or the directory it couldn't list, is left out and the walk goes
+// on with the rest.
+func skipWalkError(d fs.DirEntry) error {
+	if d != nil && d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// walkErrorNote tells the model which paths a walk had to leave out because
+// they couldn't be read, or returns "" if there are none.
+func walkErrorNote(unreadable []string) string {
+	if len(unreadable) == 0 {
+		return ""
+	}
+	shown := unreadable[:min(len(unreadable), 10)]
+	note := fmt.Sprintf("(could not read %d path(s): %s", len(unreadable), strings.Join(shown, ", "))
+	if len(shown) < len(unreadable) {
+		note += ", ..."
+	}
+	return note + ")\n"
+}
+
+// symlinkInWorkspace reports whether the symlink at p leads to something that
+// exists inside the workspace, so callers reading through it can't see more
+// than the tools taking a path can.
+func symlinkInWorkspace(p string) bool {
+	_, err := os.Stat(p)
+	if err != nil {
+		return false
+	}
+	_, err = workspace.Resolve(p)
+	return err == nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalkFiles(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	defer func(previous *Workspace) { workspace = previous }(workspace)
+	workspace = w
+
+	root := w.Root()
+	files := map[string]string{
+		".gitignore":         "*.log\n/build/\n!keep.log\n",
+		".git/HEAD":          "ref: refs/heads/main\n",
+		"main.go":            "package main\n",
+		"debug.log":          "log\n",
+		"keep.log":           "log\n",
+		"build/out":          "binary\n",
+		"sub/build/x.go":     "package build\n",
+		"sub/.gitignore":     "generated/\n[!a]*.tmp\n",
+		"sub/generated/g.go": "package generated\n",
+		"sub/a.tmp":          "tmp\n",
+		"sub/b.tmp":          "tmp\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+
+	walk := func(dir string, respectGitignore bool) []string {
+		found := []string{}
+		err := walkFiles(dir, respectGitignore, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				found = append(found, filepath.ToSlash(w.Rel(path)))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to walk: %v", err)
+		}
+		return found
+	}
+
+	// happy path: anchored, negated, nested and directory-only rules
+	expected := []string{".gitignore", "keep.log", "main.go", "sub/.gitignore", "sub/a.tmp", "sub/build/x.go"}
+	found := walk(root, true)
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+
+	// test walking a subdirectory still applies the root .gitignore
+	os.WriteFile(filepath.Join(root, "sub", "trace.log"), []byte("log\n"), 0644)
+	expected = []string{"sub/.gitignore", "sub/a.tmp", "sub/build/x.go"}
+	found = walk(filepath.Join(root, "sub"), true)
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected %v, got %v", expected, found)
+	}
+
+	// test not respecting .gitignore still skips .git
+	found = walk(root, false)
+	if len(found) != len(files) {
+		t.Fatalf("expected %d files, got %v", len(files), found)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultGlobResults is how many files a glob returns when the model doesn't
+// ask for a different limit.
+const defaultGlobResults = 100
+
+var GlobDefinition = ToolDefinition{
+	Name: "glob",
+	Description: `Find files by name with a glob pattern.
+
+The 'pattern' is matched against paths relative to 'path', which defaults to the workspace root. Besides the usual '*', '?' and '[...]', a '**' segment matches any number of directories, e.g. "**/*.go" or "internal/**/*_test.go". Note that "*.go" only matches files directly in 'path'.
+
+Returns the matching file paths relative to the workspace root, most recently modified first. .git directories and files excluded by .gitignore are skipped.
+`,
+	InputSchema: GlobInputSchema,
+	Function:    Glob,
+}
+
+type GlobInput struct {
+	Pattern string `json:"pattern" jsonschema_description:"The glob pattern to match, e.g. **/*.go"`
+	Path    string `json:"path,omitempty" jsonschema_description:"Optional directory to search in. Defaults to the workspace root."`
+	Limit   int    `json:"limit,omitempty" jsonschema_description:"Maximum number of files to return. Defaults to 100."`
+}
+
+var GlobInputSchema = GenerateSchema[GlobInput]()
+
+func Glob(ctx context.Context, input json.RawMessage) (string, error) {
+	globInput := GlobInput{}
+	err := json.Unmarshal(input, &globInput)
+	if err != nil {
+		return "", err
+	}
+
+	if globInput.Pattern == "" || globInput.Limit < 0 {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	limit := globInput.Limit
+	if limit == 0 {
+		limit = defaultGlobResults
+	}
+
+	dir, err := workspace.Resolve(globInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	type match struct {
+		path    string
+		modTime time.Time
+	}
+	matches := []match{}
+	unreadable := []string{}
+	err = walkFiles(dir, true, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			unreadable = append(unreadable, filepath.ToSlash(workspace.Rel(path)))
+			return skipWalkError(d)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !matchGlob(globInput.Pattern, filepath.ToSlash(rel)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// the file was removed while walking
+			return nil
+		}
+		matches = append(matches, match{path: filepath.ToSlash(workspace.Rel(path)), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		if len(unreadable) > 0 {
+			return "No files found\n" + walkErrorNote(unreadable), nil
+		}
+		return "No files found", nil
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].modTime.Equal(matches[j].modTime) {
+			return matches[i].modTime.After(matches[j].modTime)
+		}
+		return matches[i].path < matches[j].path
+	})
+
+	output := strings.Builder{}
+	for _, m := range matches[:min(limit, len(matches))] {
+		output.WriteString(m.path + "\n")
+	}
+	if len(matches) > limit {
+		output.WriteString(fmt.Sprintf("(showing the %d most recently modified of %d files; use a more specific pattern to see others)\n", limit, len(matches)))
+	}
+	output.WriteString(walkErrorNote(unreadable))
+	return output.String(), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// matchGlob reports whether name matches the slash-separated glob pattern.
+// Besides the path.Match syntax, a "**" segment matches any number of
+// directories, so "internal/**/*_test.go" matches "internal/a/b/x_test.go" and
+// "internal/x_test.go".
+func matchGlob(pattern, name string) bool {
+	pattern = cleanGlobPath(pattern)
+	name = cleanGlobPath(name)
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchPathGlob is matchGlob, except that a pattern without a slash is matched
+// against the base name, so "*.go" matches Go files in any directory.
+func matchPathGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") && matchGlob(pattern, path.Base(cleanGlobPath(name))) {
+		return true
+	}
+	return matchGlob(pattern, name)
+}
+
+func matchGlobSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// try every possible number of directories for the "**"
+			for i := 0; i <= len(name); i++ {
+				if matchGlobSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+func cleanGlobPath(p string) string {
+	p = path.Clean(filepath.ToSlash(p))
+	return strings.TrimPrefix(p, "./")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlob(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	defer func(previous *Workspace) { workspace = previous }(workspace)
+	workspace = w
+
+	root := w.Root()
+	files := []string{".gitignore", "main.go", "internal/a/a_test.go", "internal/b_test.go", "internal/b.go", "vendor/v_test.go"}
+	now := time.Now()
+	for i, name := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		os.WriteFile(filepath.Join(root, name), []byte("package x\n"), 0644)
+		// later files in the list are more recent
+		modTime := now.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(filepath.Join(root, name), modTime, modTime)
+	}
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("vendor/\n"), 0644)
+
+	// happy path: newest first, ignored files skipped
+	input := json.RawMessage(`{"pattern": "**/*_test.go"}`)
+	result, err := Glob(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+	expected := "internal/b_test.go\ninternal/a/a_test.go\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test a pattern relative to a path
+	input = json.RawMessage(`{"pattern": "*.go", "path": "internal"}`)
+	result, err = Glob(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+	expected = "internal/b.go\ninternal/b_test.go\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test the limit
+	input = json.RawMessage(`{"pattern": "**/*.go", "limit": 1}`)
+	result, err = Glob(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+	expected = "internal/b.go\n(showing the 1 most recently modified of 4 files; use a more specific pattern to see others)\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test no matches
+	input = json.RawMessage(`{"pattern": "*.rs"}`)
+	result, err = Glob(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+	if result != "No files found" {
+		t.Fatalf("expected No files found, got %q", result)
+	}
+
+	// test a directory that can't be read is left out and noted
+	newUnreadableDir(t, root, "deep")
+	result, err = Glob(context.Background(), json.RawMessage(`{"pattern": "main.go"}`))
+	if err != nil || !strings.HasPrefix(result, "main.go\n(could not read 1 path(s): deep/") {
+		t.Fatalf("expected the match and a note on the unreadable directory, got %q and %v", result, err)
+	}
+}
@@ -5,6 +5,7 @@ go 1.22.5
 require (
 	github.com/anthropics/anthropic-sdk-go v1.6.2
 	github.com/invopop/jsonschema v0.13.0
+	golang.org/x/tools v0.30.0
 )
 
 require (
@@ -16,5 +17,7 @@ require (
 	github.com/tidwall/pretty v1.2.1 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
+	golang.org/x/mod v0.23.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
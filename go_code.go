@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// maxGoReferences is how many references go_references lists before it stops.
+const maxGoReferences = 200
+
+var GoSymbolsDefinition = ToolDefinition{
+	Name: "go_symbols",
+	Description: `List the top-level symbols declared in a Go file or package.
+
+'path' is a .go file or a package directory (not searched recursively). For each file, lists its functions and methods with their signatures, and its types, variables and constants, each with the line it's declared on.
+`,
+	InputSchema: GoSymbolsInputSchema,
+	Function:    GoSymbols,
+}
+
+type GoSymbolsInput struct {
+	Path string `json:"path,omitempty" jsonschema_description:"A .go file or a package directory. Defaults to the workspace root."`
+}
+
+var GoSymbolsInputSchema = GenerateSchema[GoSymbolsInput]()
+
+func GoSymbols(ctx context.Context, input json.RawMessage) (string, error) {
+	goSymbolsInput := GoSymbolsInput{}
+	err := json.Unmarshal(input, &goSymbolsInput)
+	if err != nil {
+		return "", err
+	}
+
+	fset, files, err := parseGoPath(goSymbolsInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	output := strings.Builder{}
+	for _, file := range files {
+		if output.Len() > 0 {
+			output.WriteString("\n")
+		}
+		output.WriteString(file.name + "\n")
+		for _, decl := range file.ast.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				output.WriteString(fmt.Sprintf("  %d: %s\n", fset.Position(decl.Pos()).Line, oneLine(file.source(decl.Pos(), decl.Type.End()))))
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						output.WriteString(fmt.Sprintf("  %d: type %s %s\n", fset.Position(spec.Pos()).Line, spec.Name.Name, typeKind(file, spec)))
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							if name.Name == "_" {
+								continue
+							}
+							symbol := decl.Tok.String() + " " + name.Name
+							if spec.Type != nil {
+								symbol += " " + oneLine(file.source(spec.Type.Pos(), spec.Type.End()))
+							}
+							output.WriteString(fmt.Sprintf("  %d: %s\n", fset.Position(name.Pos()).Line, symbol))
+						}
+					}
+				}
+			}
+		}
+	}
+	return output.String(), nil
+}
+
+var GoDocDefinition = ToolDefinition{
+	Name: "go_doc",
+	Description: `Show the declaration and doc comment of a Go symbol.
+
+'symbol' is a top-level name in the package at 'path', e.g. "NewAgent", or a method as "Type.Method", e.g. "Agent.Run". For functions and methods it shows the signature, for types, variables and constants the whole declaration.
+`,
+	InputSchema: GoDocInputSchema,
+	Function:    GoDoc,
+}
+
+type GoDocInput struct {
+	Symbol string `json:"symbol" jsonschema_description:"The symbol, e.g. NewAgent or Agent.Run"`
+	Path   string `json:"path,omitempty" jsonschema_description:"The package directory or a .go file in it. Defaults to the workspace root."`
+}
+
+var GoDocInputSchema = GenerateSchema[GoDocInput]()
+
+func GoDoc(ctx context.Context, input json.RawMessage) (string, error) {
+	goDocInput := GoDocInput{}
+	err := json.Unmarshal(input, &goDocInput)
+	if err != nil {
+		return "", err
+	}
+
+	if goDocInput.Symbol == "" {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	receiver, name, isMethod := strings.Cut(goDocInput.Symbol, ".")
+	if !isMethod {
+		name, receiver = receiver, ""
+	}
+
+	path := goDocInput.Path
+	if strings.HasSuffix(path, ".go") {
+		path = filepath.Dir(path)
+	}
+	fset, files, err := parseGoPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		for _, decl := range file.ast.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Name.Name != name || receiverName(decl) != receiver {
+					continue
+				}
+				return formatGoDoc(fset, file, decl.Pos(), decl.Doc, file.source(decl.Pos(), decl.Type.End())), nil
+			case *ast.GenDecl:
+				if receiver != "" {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					doc := decl.Doc
+					var names []*ast.Ident
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						names = []*ast.Ident{spec.Name}
+						if spec.Doc != nil {
+							doc = spec.Doc
+						}
+					case *ast.ValueSpec:
+						names = spec.Names
+						if spec.Doc != nil {
+							doc = spec.Doc
+						}
+					}
+					for _, ident := range names {
+						if ident.Name != name {
+							continue
+						}
+						declaration := decl.Tok.String() + " " + file.source(spec.Pos(), spec.End())
+						if decl.Lparen.IsValid() && len(decl.Specs) == 1 {
+							declaration = file.source(decl.Pos(), decl.End())
+						}
+						return formatGoDoc(fset, file, spec.Pos(), doc, declaration), nil
+					}
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("symbol %s not found", goDocInput.Symbol)
+}
+
+func formatGoDoc(fset *token.FileSet, file goFile, pos token.Pos, doc *ast.CommentGroup, declaration string) string {
+	output := strings.Builder{}
+	output.WriteString(fmt.Sprintf("%s:%d\n", file.name, fset.Position(pos).Line))
+	if doc != nil {
+		output.WriteString(file.source(doc.Pos(), doc.End()) + "\n")
+	}
+	output.WriteString(declaration + "\n")
+	return output.String()
+}
+
+var GoDefinitionDefinition = ToolDefinition{
+	Name: "go_definition",
+	Description: `Find where a Go identifier is declared.
+
+Give the file and line where the identifier is used and its name, and the package is type-checked to find the declaration it refers to, even in another package or a dependency.
+`,
+	InputSchema: GoDefinitionInputSchema,
+	Function:    GoDefinition,
+}
+
+// GoIdentifierInput picks out one use of an identifier in a Go file.
+type GoIdentifierInput struct {
+	Path   string `json:"path" jsonschema_description:"The .go file the identifier is used in"`
+	Line   int    `json:"line" jsonschema_description:"The 1-indexed line the identifier is on"`
+	Symbol string `json:"symbol" jsonschema_description:"The identifier, e.g. NewAgent. For a selector like a.Run, give just Run."`
+}
+
+var GoDefinitionInputSchema = GenerateSchema[GoIdentifierInput]()
+
+func GoDefinition(ctx context.Context, input json.RawMessage) (string, error) {
+	identifierInput := GoIdentifierInput{}
+	err := json.Unmarshal(input, &identifierInput)
+	if err != nil {
+		return "", err
+	}
+
+	pkgs, obj, err := lookupGoObject(ctx, identifierInput, false)
+	if err != nil {
+		return "", err
+	}
+	if !obj.Pos().IsValid() {
+		return fmt.Sprintf("%s is predeclared", obj.Name()), nil
+	}
+
+	position := pkgs[0].Fset.Position(obj.Pos())
+	return fmt.Sprintf("%s\n%s:%d:%d: %s\n", describeGoObject(obj), displayPath(position.Filename), position.Line, position.Column, sourceLine(position.Filename, position.Line)), nil
+}
+
+var GoReferencesDefinition = ToolDefinition{
+	Name: "go_references",
+	Description: `Find every reference to a Go identifier across the module.
+
+Give the file and line where the identifier is declared or used and its name. Every package of the Go module in the workspace, including tests, is type-checked, so only real references to the same declaration are listed, not other things with the same name.
+`,
+	InputSchema: GoReferencesInputSchema,
+	Function:    GoReferences,
+}
+
+var GoReferencesInputSchema = GenerateSchema[GoIdentifierInput]()
+
+func GoReferences(ctx context.Context, input json.RawMessage) (string, error) {
+	identifierInput := GoIdentifierInput{}
+	err := json.Unmarshal(input, &identifierInput)
+	if err != nil {
+		return "", err
+	}
+
+	pkgs, obj, err := lookupGoObject(ctx, identifierInput, true)
+	if err != nil {
+		return "", err
+	}
+	if !obj.Pos().IsValid() {
+		return "", fmt.Errorf("%s is predeclared", obj.Name())
+	}
+
+	references := findGoReferences(pkgs, pkgs[0].Fset.Position(obj.Pos()))
+	output := strings.Builder{}
+	output.WriteString(fmt.Sprintf("%d references to %s\n", len(references), describeGoObject(obj)))
+	for i, position := range references {
+		if i == maxGoReferences {
+			output.WriteString(fmt.Sprintf("(%d more not shown)\n", len(references)-maxGoReferences))
+			break
+		}
+		output.WriteString(fmt.Sprintf("%s:%d:%d: %s\n", displayPath(position.Filename), position.Line, position.Column, sourceLine(position.Filename, position.Line)))
+	}
+	return output.String(), nil
+}
+
+// goFile is a parsed Go file and its source.
+type goFile struct {
+	name string
+	ast  *ast.File
+	src  []byte
+	base int
+}
+
+func (f goFile) source(start, end token.Pos) string {
+	return string(f.src[int(start)-f.base : int(end)-f.base])
+}
+
+// parseGoPath parses a .go file, or the .go files of a directory, sorted by name.
+func parseGoPath(p string) (*token.FileSet, []goFile, error) {
+	resolved, err := workspace.Resolve(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := []string{resolved}
+	if info.IsDir() {
+		paths, err = filepath.Glob(filepath.Join(resolved, "*.go"))
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(paths)
+		if len(paths) == 0 {
+			return nil, nil, fmt.Errorf("no Go files in %s", displayPath(resolved))
+		}
+	}
+
+	fset := token.NewFileSet()
+	files := []goFile{}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		parsed, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, goFile{
+			name: displayPath(path),
+			ast:  parsed,
+			src:  src,
+			base: fset.File(parsed.Pos()).Base(),
+		})
+	}
+	return fset, files, nil
+}
+
+// loadGoPackages type-checks the packages matching the patterns, with their
+// tests, from the workspace root. Dependencies are type-checked from source
+// rather than read from export data, so the tools don't depend on the export
+// data format of whichever go command is installed. Only their declarations
+// are needed, so the bodies of functions outside the workspace are dropped
+// unchecked, which makes loading them several times faster.
+func loadGoPackages(ctx context.Context, patterns ...string) ([]*packages.Package, error) {
+	config := &packages.Config{
+		Context:   ctx,
+		Mode:      packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedDeps | packages.NeedTypesSizes | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:       workspace.Root(),
+		Tests:     true,
+		ParseFile: parseGoDeclarations,
+	}
+	pkgs, err := packages.Load(config, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	// leave out the generated main packages of the test binaries
+	kept := []*packages.Package{}
+	for _, pkg := range pkgs {
+		if !strings.HasSuffix(pkg.ID, ".test") {
+			kept = append(kept, pkg)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("no Go packages found")
+	}
+	return kept, nil
+}
+
+// parseGoDeclarations parses a file for loadGoPackages: files in the
+// workspace in full, and others without their function bodies.
+func parseGoDeclarations(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+	if isWithin(workspace.Root(), filename) {
+		return parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments)
+	}
+	file, err := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+	if file != nil {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				fn.Body = nil
+			}
+		}
+	}
+	return file, err
+}
+
+// goPackageCache keeps the packages the go_* tools loaded, by workspace root
+// and patterns, for the later calls of the same turn. It is emptied when a
+// turn starts and after every call to a mutating tool, which may change the code.
+var goPackageCache = struct {
+	sync.Mutex
+	pkgs map[string][]*packages.Package
+}{}
+
+// loadGoPackagesCached is loadGoPackages, reusing the packages loaded for the
+// same patterns since the cache was last emptied.
+func loadGoPackagesCached(ctx context.Context, patterns ...string) ([]*packages.Package, error) {
+	goPackageCache.Lock()
+	defer goPackageCache.Unlock()
+	key := workspace.Root() + "\x00" + strings.Join(patterns, "\x00")
+	if pkgs, ok := goPackageCache.pkgs[key]; ok {
+		return pkgs, nil
+	}
+	pkgs, err := loadGoPackages(ctx, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if goPackageCache.pkgs == nil {
+		goPackageCache.pkgs = map[string][]*packages.Package{}
+	}
+	goPackageCache.pkgs[key] = pkgs
+	return pkgs, nil
+}
+
+// forgetGoPackages empties the package cache.
+func forgetGoPackages() {
+	goPackageCache.Lock()
+	defer goPackageCache.Unlock()
+	goPackageCache.pkgs = nil
+}
+
+// lookupGoObject finds the object the identifier in the input refers to. With
+// wholeModule, the packages returned are all of the module's; otherwise just
+// those containing the file.
+func lookupGoObject(ctx context.Context, input GoIdentifierInput, wholeModule bool) ([]*packages.Package, types.Object, error) {
+	if input.Path == "" || input.Line <= 0 || input.Symbol == "" {
+		return nil, nil, fmt.Errorf("invalid input parameters")
+	}
+	path, err := workspace.Resolve(input.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	patterns := []string{"file=" + path}
+	if wholeModule {
+		patterns = []string{"./..."}
+	}
+	pkgs, err := loadGoPackagesCached(ctx, patterns...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		for _, file := range pkg.Syntax {
+			if pkg.Fset.Position(file.Pos()).Filename != path {
+				continue
+			}
+			var obj types.Object
+			ast.Inspect(file, func(node ast.Node) bool {
+				ident, ok := node.(*ast.Ident)
+				if !ok || obj != nil || ident.Name != input.Symbol || pkg.Fset.Position(ident.Pos()).Line != input.Line {
+					return obj == nil
+				}
+				obj = pkg.TypesInfo.ObjectOf(ident)
+				return false
+			})
+			if obj != nil {
+				return pkgs, obj, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no identifier %s found on line %d of %s", input.Symbol, input.Line, input.Path)
+}
+
+// findGoReferences returns the positions of every identifier that refers to
+// the object declared at declaration, sorted. Objects are compared by where
+// they are declared, because the test variant of a package is type-checked
+// separately and has its own objects for the same declarations.
+func findGoReferences(pkgs []*packages.Package, declaration token.Position) []token.Position {
+	seen := map[string]bool{}
+	references := []token.Position{}
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		for ident, obj := range pkg.TypesInfo.Uses {
+			addGoReference(pkg, ident, obj, declaration, seen, &references)
+		}
+		for ident, obj := range pkg.TypesInfo.Defs {
+			addGoReference(pkg, ident, obj, declaration, seen, &references)
+		}
+	}
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].Filename != references[j].Filename {
+			return references[i].Filename < references[j].Filename
+		}
+		if references[i].Line != references[j].Line {
+			return references[i].Line < references[j].Line
+		}
+		return references[i].Column < references[j].Column
+	})
+	return references
+}
+
+func addGoReference(pkg *packages.Package, ident *ast.Ident, obj types.Object, declaration token.Position, seen map[string]bool, references *[]token.Position) {
+	if obj == nil || !samePosition(pkg.Fset.Position(obj.Pos()), declaration) {
+		return
+	}
+	position := pkg.Fset.Position(ident.Pos())
+	// test variants of a package contain the same files, so skip repeats
+	if seen[position.String()] {
+		return
+	}
+	seen[position.String()] = true
+	*references = append(*references, position)
+}
+
+func samePosition(a, b token.Position) bool {
+	return a.Filename == b.Filename && a.Line == b.Line && a.Column == b.Column
+}
+
+func describeGoObject(obj types.Object) string {
+	return types.ObjectString(obj, func(pkg *types.Package) string { return pkg.Name() })
+}
+
+func receiverName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func typeKind(file goFile, spec *ast.TypeSpec) string {
+	switch spec.Type.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	}
+	kind := oneLine(file.source(spec.Type.Pos(), spec.Type.End()))
+	if spec.Assign.IsValid() {
+		kind = "= " + kind
+	}
+	return kind
+}
+
+// oneLine collapses the whitespace in source code, e.g. a signature split over
+// several lines, into single spaces.
+func oneLine(source string) string {
+	return strings.Join(strings.Fields(source), " ")
+}
+
+// displayPath shows a path relative to the workspace root if it is inside it.
+func displayPath(path string) string {
+	return filepath.ToSlash(workspace.Rel(path))
+}
+
+// sourceLine returns a line of a file without its indentation, or "" if it
+// can't be read.
+func sourceLine(path string, line int) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(content), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGoTestModule creates a small Go module in a new workspace and makes it
+// the workspace for the rest of the test.
+func newGoTestModule(t *testing.T) string {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	previous := workspace
+	t.Cleanup(func() { workspace = previous })
+	workspace = w
+
+	root := w.Root()
+	files := map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.22\n",
+		"greet/greet.go": `package greet
+
+// Greeter says hello.
+type Greeter struct {
+	Name string
+}
+
+// Hello returns a greeting
+// for the given name.
+func Hello(name string) string {
+	return "hello " + name
+}
+
+// Greet greets the greeter's name.
+func (g *Greeter) Greet() string {
+	return Hello(g.Name)
+}
+
+const (
+	// Version is the version.
+	Version = "1"
+	other   = 2
+)
+`,
+		"greet/greet_test.go": `package greet
+
+import "testing"
+
+func TestHello(t *testing.T) {
+	if Hello("x") != "hello x" {
+		t.Fatal("wrong greeting")
+	}
+}
+`,
+		"main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/m/greet"
+)
+
+func main() {
+	fmt.Println(greet.Hello("world"))
+	Hello := 1
+	_ = Hello
+}
+`,
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+	return root
+}
+
+func TestGoSymbols(t *testing.T) {
+	newGoTestModule(t)
+
+	// happy path
+	result, err := GoSymbols(context.Background(), json.RawMessage(`{"path": "greet"}`))
+	if err != nil {
+		t.Fatalf("failed to list symbols: %v", err)
+	}
+	expected := "greet/greet.go\n  4: type Greeter struct\n  10: func Hello(name string) string\n  15: func (g *Greeter) Greet() string\n  21: const Version\n  22: const other\n\ngreet/greet_test.go\n  5: func TestHello(t *testing.T)\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test a directory without Go files
+	os.Mkdir(filepath.Join(workspace.Root(), "empty"), 0755)
+	_, err = GoSymbols(context.Background(), json.RawMessage(`{"path": "empty"}`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestGoDoc(t *testing.T) {
+	newGoTestModule(t)
+
+	// happy path
+	result, err := GoDoc(context.Background(), json.RawMessage(`{"symbol": "Hello", "path": "greet"}`))
+	if err != nil {
+		t.Fatalf("failed to show doc: %v", err)
+	}
+	expected := "greet/greet.go:10\n// Hello returns a greeting\n// for the given name.\nfunc Hello(name string) string\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test a method and a grouped constant
+	result, err = GoDoc(context.Background(), json.RawMessage(`{"symbol": "Greeter.Greet", "path": "greet/greet.go"}`))
+	if err != nil {
+		t.Fatalf("failed to show doc: %v", err)
+	}
+	expected = "greet/greet.go:15\n// Greet greets the greeter's name.\nfunc (g *Greeter) Greet() string\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+	result, err = GoDoc(context.Background(), json.RawMessage(`{"symbol": "Version", "path": "greet"}`))
+	if err != nil {
+		t.Fatalf("failed to show doc: %v", err)
+	}
+	expected = "greet/greet.go:21\n// Version is the version.\nconst Version = \"1\"\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test a missing symbol
+	_, err = GoDoc(context.Background(), json.RawMessage(`{"symbol": "Goodbye", "path": "greet"}`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestGoDefinitionAndReferences(t *testing.T) {
+	newGoTestModule(t)
+
+	// happy path: a use in another package
+	result, err := GoDefinition(context.Background(), json.RawMessage(`{"path": "main.go", "line": 10, "symbol": "Hello"}`))
+	if err != nil {
+		t.Fatalf("failed to find definition: %v", err)
+	}
+	expected := "func greet.Hello(name string) string\ngreet/greet.go:10:6: func Hello(name string) string {\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test references across packages and tests, but not the local variable
+	result, err = GoReferences(context.Background(), json.RawMessage(`{"path": "greet/greet.go", "line": 10, "symbol": "Hello"}`))
+	if err != nil {
+		t.Fatalf("failed to find references: %v", err)
+	}
+	if !strings.HasPrefix(result, "4 references to func greet.Hello(name string) string\n") {
+		t.Fatalf("expected 4 references, got %q", result)
+	}
+	for _, reference := range []string{"greet/greet.go:10:6:", "greet/greet.go:16:9:", "greet/greet_test.go:6:5:", "main.go:10:20:"} {
+		if !strings.Contains(result, reference) {
+			t.Fatalf("expected a reference at %s, got %q", reference, result)
+		}
+	}
+
+	// test an identifier that isn't on the line
+	_, err = GoDefinition(context.Background(), json.RawMessage(`{"path": "main.go", "line": 3, "symbol": "Hello"}`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test a declaration outside the workspace, whose function bodies aren't loaded
+	result, err = GoDefinition(context.Background(), json.RawMessage(`{"path": "main.go", "line": 10, "symbol": "Println"}`))
+	if err != nil || !strings.HasPrefix(result, "func fmt.Println(a ...any) (n int, err error)\n") || !strings.Contains(result, "print.go:") {
+		t.Fatalf("expected the definition of fmt.Println, got %q and %v", result, err)
+	}
+
+	// test the packages are reused until the cache is emptied
+	err = os.WriteFile(filepath.Join(workspace.Root(), "greet", "more.go"), []byte("package greet\n\nvar greeting = Hello(\"more\")\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	result, err = GoReferences(context.Background(), json.RawMessage(`{"path": "greet/greet.go", "line": 10, "symbol": "Hello"}`))
+	if err != nil || !strings.HasPrefix(result, "4 references") {
+		t.Fatalf("expected the cached 4 references, got %q and %v", result, err)
+	}
+	forgetGoPackages()
+	result, err = GoReferences(context.Background(), json.RawMessage(`{"path": "greet/greet.go", "line": 10, "symbol": "Hello"}`))
+	if err != nil || !strings.HasPrefix(result, "5 references") {
+		t.Fatalf("expected 5 references after emptying the cache, got %q and %v", result, err)
+	}
+}
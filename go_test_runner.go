@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTestOutputLines is how many lines of output are shown for each failure.
+	maxTestOutputLines = 40
+	// maxListedTests is how many passed or skipped test names are listed.
+	maxListedTests = 50
+)
+
+var RunGoTestsDefinition = ToolDefinition{
+	Name: "run_go_tests",
+	Description: `Run Go tests with 'go test -json' in the workspace root and summarize the results.
+
+Returns the number of passed, failed and skipped tests, the output of every failed test with the file and line it failed at, build errors, and the names of the tests that passed or were skipped.
+
+Use 'packages' to choose what to test, e.g. "./..." or "./internal/parser", and 'run' to only run the tests matching a regular expression, like 'go test -run'.
+
+The user must approve test runs, since tests run arbitrary code.
+`,
+	InputSchema: RunGoTestsInputSchema,
+	Function:    RunGoTests,
+	Mutating:    true,
+	// the run's own timeout, at most maxCommandTimeout, comes first
+	Timeout: maxCommandTimeout + time.Minute,
+}
+
+type RunGoTestsInput struct {
+	Packages       string `json:"packages,omitempty" jsonschema_description:"The package pattern to test. Defaults to ./..."`
+	Run            string `json:"run,omitempty" jsonschema_description:"Optional regular expression selecting the tests to run, as for go test -run"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" jsonschema_description:"Optional timeout in seconds. Defaults to the configured command timeout."`
+}
+
+var RunGoTestsInputSchema = GenerateSchema[RunGoTestsInput]()
+
+// testEvent is a line of 'go test -json' output, see 'go doc test2json'.
+type testEvent struct {
+	Action     string
+	Package    string
+	ImportPath string
+	Test       string
+	Elapsed    float64
+	Output     string
+}
+
+// testResult is what happened to one test or, with an empty test, one package.
+type testResult struct {
+	pkg     string
+	test    string
+	action  string
+	elapsed float64
+	output  []string
+}
+
+func RunGoTests(ctx context.Context, input json.RawMessage) (string, error) {
+	runGoTestsInput := RunGoTestsInput{}
+	err := json.Unmarshal(input, &runGoTestsInput)
+	if err != nil {
+		return "", err
+	}
+
+	packagesPattern := runGoTestsInput.Packages
+	if packagesPattern == "" {
+		packagesPattern = "./..."
+	}
+	timeout := commandTimeout
+	if runGoTestsInput.TimeoutSeconds > 0 {
+		timeout = time.Duration(runGoTestsInput.TimeoutSeconds) * time.Second
+	}
+	if timeout > maxCommandTimeout {
+		timeout = maxCommandTimeout
+	}
+
+	args := []string{"test", "-json"}
+	if runGoTestsInput.Run != "" {
+		args = append(args, "-run", runGoTestsInput.Run)
+	}
+	args = append(args, strings.Fields(packagesPattern)...)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = workspace.Root()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	err = cmd.Run()
+	if ctx.Err() == context.Canceled {
+		return "", ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", err
+	}
+
+	summary := summarizeTestEvents(&stdout, stderr.String())
+	if ctx.Err() == context.DeadlineExceeded {
+		summary = fmt.Sprintf("go test was killed after timing out after %s, so the results are incomplete\n\n%s", timeout, summary)
+	}
+	return truncateOutput(summary, maxCommandOutput), nil
+}
+
+var testLocationRegexp = regexp.MustCompile(`^\s*([\w./-]+\.go:\d+):`)
+
+// summarizeTestEvents turns the 'go test -json' event stream into a summary
+// for the model. Lines that aren't events, such as build errors printed by
+// older versions of go, and stderr are shown as build output.
+func summarizeTestEvents(events *bytes.Buffer, stderr string) string {
+	results := map[string]*testResult{}
+	order := []*testResult{}
+	buildOutput := []string{}
+	get := func(pkg, test string) *testResult {
+		key := pkg + "\x00" + test
+		result, ok := results[key]
+		if !ok {
+			result = &testResult{pkg: pkg, test: test}
+			results[key] = result
+			order = append(order, result)
+		}
+		return result
+	}
+
+	scanner := bufio.NewScanner(events)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		event := testEvent{}
+		if json.Unmarshal(scanner.Bytes(), &event) != nil || event.Action == "" {
+			buildOutput = append(buildOutput, scanner.Text())
+			continue
+		}
+		switch event.Action {
+		case "build-output":
+			buildOutput = append(buildOutput, strings.TrimRight(event.Output, "\n"))
+		case "output":
+			result := get(event.Package, event.Test)
+			result.output = append(result.output, strings.TrimRight(event.Output, "\n"))
+		case "pass", "fail", "skip":
+			result := get(event.Package, event.Test)
+			result.action = event.Action
+			result.elapsed = event.Elapsed
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		if line != "" {
+			buildOutput = append(buildOutput, line)
+		}
+	}
+
+	var passed, failed, skipped []*testResult
+	var packagesFailed []*testResult
+	packageCount := 0
+	for _, result := range order {
+		if result.test == "" {
+			packageCount++
+			if result.action == "fail" {
+				packagesFailed = append(packagesFailed, result)
+			}
+			continue
+		}
+		// a test with subtests passes or fails along with them, so only the
+		// subtests count, unless it failed with output of its own
+		if hasSubtest(order, result) && (result.action != "fail" || len(testOutputLines(result.output)) == 0) {
+			continue
+		}
+		switch result.action {
+		case "pass":
+			passed = append(passed, result)
+		case "fail":
+			failed = append(failed, result)
+		case "skip":
+			skipped = append(skipped, result)
+		}
+	}
+
+	output := strings.Builder{}
+	status := "PASS"
+	if len(failed) > 0 || len(packagesFailed) > 0 || (packageCount == 0 && len(buildOutput) > 0) {
+		status = "FAIL"
+	}
+	output.WriteString(fmt.Sprintf("%s: %d passed, %d failed, %d skipped in %d packages\n", status, len(passed), len(failed), len(skipped), packageCount))
+
+	if len(buildOutput) > 0 {
+		output.WriteString("\nbuild output:\n" + limitLines(buildOutput, maxTestOutputLines) + "\n")
+	}
+
+	for _, result := range failed {
+		lines := testOutputLines(result.output)
+		location := ""
+		for _, line := range lines {
+			if match := testLocationRegexp.FindStringSubmatch(line); match != nil {
+				location = ", at " + match[1]
+				break
+			}
+		}
+		output.WriteString(fmt.Sprintf("\n--- FAIL: %s (%s%s, %.2fs)\n", result.test, result.pkg, location, result.elapsed))
+		if len(lines) > 0 {
+			output.WriteString(limitLines(lines, maxTestOutputLines) + "\n")
+		}
+	}
+
+	for _, result := range packagesFailed {
+		hasFailedTest := false
+		for _, test := range failed {
+			if test.pkg == result.pkg {
+				hasFailedTest = true
+				break
+			}
+		}
+		if hasFailedTest {
+			continue
+		}
+		// the package failed without a failing test, e.g. a panic in TestMain or init
+		output.WriteString(fmt.Sprintf("\n--- FAIL: package %s\n", result.pkg))
+		lines := testOutputLines(result.output)
+		if len(lines) > 0 {
+			output.WriteString(limitLines(lines, maxTestOutputLines) + "\n")
+		}
+	}
+
+	if len(skipped) > 0 {
+		output.WriteString("\nskipped: " + listTests(skipped) + "\n")
+	}
+	if len(passed) > 0 {
+		output.WriteString("\npassed: " + listTests(passed) + "\n")
+	}
+	return output.String()
+}
+
+// testOutputLines drops the lines the test framework prints about running
+// tests and packages, keeping what the tests themselves printed.
+func testOutputLines(output []string) []string {
+	lines := []string{}
+	for _, line := range output {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "=== "),
+			strings.HasPrefix(trimmed, "--- PASS"),
+			strings.HasPrefix(trimmed, "--- FAIL"),
+			strings.HasPrefix(trimmed, "--- SKIP"),
+			trimmed == "PASS",
+			trimmed == "FAIL",
+			strings.HasPrefix(trimmed, "ok  "),
+			strings.HasPrefix(trimmed, "FAIL\t"),
+			strings.HasPrefix(trimmed, "?   "),
+			trimmed == "":
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func hasSubtest(results []*testResult, parent *testResult) bool {
+	for _, result := range results {
+		if result.pkg == parent.pkg && strings.HasPrefix(result.test, parent.test+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// limitLines joins the lines, leaving out the middle if there are too many:
+// the first lines tend to hold the first error and the last ones a panic's cause.
+func limitLines(lines []string, limit int) string {
+	if len(lines) <= limit {
+		return strings.Join(lines, "\n")
+	}
+	head := limit / 3
+	tail := limit - head
+	return fmt.Sprintf("%s\n... [%d lines omitted] ...\n%s", strings.Join(lines[:head], "\n"), len(lines)-limit, strings.Join(lines[len(lines)-tail:], "\n"))
+}
+
+func listTests(results []*testResult) string {
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.test)
+	}
+	sort.Strings(names)
+	if len(names) > maxListedTests {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:maxListedTests], ", "), len(names)-maxListedTests)
+	}
+	return strings.Join(names, ", ")
+}
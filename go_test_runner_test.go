@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunGoTests(t *testing.T) {
+	root := newGoTestModule(t)
+	os.WriteFile(filepath.Join(root, "greet", "more_test.go"), []byte(`package greet
+
+import "testing"
+
+func TestGreet(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		g := Greeter{}
+		if g.Greet() != "hello" {
+			t.Errorf("expected hello, got %q", g.Greet())
+		}
+	})
+	t.Run("named", func(t *testing.T) {})
+}
+
+func TestLater(t *testing.T) {
+	t.Skip("not yet")
+}
+
+func TestTable(t *testing.T) {
+	t.Run("a", func(t *testing.T) {})
+}
+`), 0644)
+
+	// happy path: failures with their location, skipped and passed tests, counting subtests rather than their parents
+	result, err := RunGoTests(context.Background(), json.RawMessage(`{"packages": "./greet"}`))
+	if err != nil {
+		t.Fatalf("failed to run tests: %v", err)
+	}
+	expected := []string{
+		"FAIL: 3 passed, 1 failed, 1 skipped in 1 packages\n",
+		"\n--- FAIL: TestGreet/empty (example.com/m/greet, at more_test.go:9, ",
+		"    more_test.go:9: expected hello, got \"hello \"\n",
+		"\nskipped: TestLater\n",
+		"\npassed: TestGreet/named, TestHello, TestTable/a\n",
+	}
+	for _, part := range expected {
+		if !strings.Contains(result, part) {
+			t.Fatalf("expected %q in %q", part, result)
+		}
+	}
+	if strings.Contains(result, "--- FAIL: TestGreet (") {
+		t.Fatalf("expected the parent test to be left out, got %q", result)
+	}
+
+	// test the run filter
+	result, err = RunGoTests(context.Background(), json.RawMessage(`{"packages": "./...", "run": "TestHello"}`))
+	if err != nil {
+		t.Fatalf("failed to run tests: %v", err)
+	}
+	if !strings.HasPrefix(result, "PASS: 1 passed, 0 failed, 0 skipped in 2 packages\n") {
+		t.Fatalf("unexpected result: %q", result)
+	}
+
+	// test a build failure
+	os.WriteFile(filepath.Join(root, "greet", "broken.go"), []byte("package greet\n\nfunc Broken() int { return \"x\" }\n"), 0644)
+	result, err = RunGoTests(context.Background(), json.RawMessage(`{"packages": "./greet"}`))
+	if err != nil {
+		t.Fatalf("failed to run tests: %v", err)
+	}
+	if !strings.HasPrefix(result, "FAIL:") || !strings.Contains(result, "broken.go:3:") {
+		t.Fatalf("expected a build failure, got %q", result)
+	}
+}
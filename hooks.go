@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/imports"
+)
+
+// PostEditHook checks or fixes files after a tool has changed them. What it
+// reports is added to the tool's result, so the model can act on it right away.
+type PostEditHook struct {
+	Name string
+	// Pattern selects the files the hook runs on, e.g. "*.go". A pattern
+	// without a slash matches the base name.
+	Pattern string
+	// Run gets the changed files that match Pattern and returns what the model
+	// should know about them, or "" if there is nothing to report.
+	Run func(ctx context.Context, paths []string) (string, error)
+}
+
+// PostEditCommand is a post-edit hook from the config file. Command runs with
+// 'sh -c' in the workspace root once for every changed file matching Pattern,
+// with the file's path in $FILE. It is reported when it exits non-zero.
+type PostEditCommand struct {
+	Pattern string `json:"pattern"`
+	Command string `json:"command"`
+}
+
+// RegisterPostEditHooks adds hooks to run after every tool call that changes files.
+func (a *Agent) RegisterPostEditHooks(hooks ...PostEditHook) {
+	a.postEditHooks = append(a.postEditHooks, hooks...)
+}
+
+// runPostEditHooks runs the hooks on the files a tool call wrote and returns
+// their reports, or "" if none of them had anything to say.
+func (a *Agent) runPostEditHooks(ctx context.Context, paths []string) string {
+	reports := []string{}
+	for _, hook := range a.postEditHooks {
+		matched := []string{}
+		for _, path := range paths {
+			if matchPathGlob(hook.Pattern, displayPath(path)) {
+				matched = append(matched, path)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		report, err := hook.Run(ctx, matched)
+		if err != nil {
+			report = fmt.Sprintf("the %s hook failed: %s", hook.Name, err.Error())
+		}
+		if report != "" {
+			reports = append(reports, strings.TrimRight(report, "\n"))
+		}
+	}
+	if len(reports) == 0 {
+		return ""
+	}
+	return "post-edit checks:\n" + strings.Join(reports, "\n")
+}
+
+// GoPostEditHook formats changed Go files and fixes their imports like
+// goimports, then reports syntax errors and, through go vet, type errors.
+func GoPostEditHook() PostEditHook {
+	return PostEditHook{Name: "go", Pattern: "*.go", Run: checkGoFiles}
+}
+
+func checkGoFiles(ctx context.Context, paths []string) (string, error) {
+	report := []string{}
+	dirs := []string{}
+	broken := map[string]bool{}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		dir := filepath.Dir(path)
+		formatted, err := imports.Process(path, src, nil)
+		if err != nil {
+			// a file that doesn't parse would only repeat the same errors in the type check
+			report = append(report, strings.ReplaceAll(err.Error(), path, displayPath(path)))
+			broken[dir] = true
+			continue
+		}
+		if !bytes.Equal(formatted, src) {
+			err = workspace.WriteFile(path, formatted)
+			if err != nil {
+				return "", err
+			}
+			report = append(report, fmt.Sprintf("%s: formatted and fixed imports, re-read it before editing", displayPath(path)))
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		if broken[dir] || !inGoModule(dir) {
+			continue
+		}
+		output, err := goVet(ctx, dir)
+		if err != nil {
+			return "", err
+		}
+		if output != "" {
+			report = append(report, output)
+		}
+	}
+	return strings.Join(report, "\n"), nil
+}
+
+// goVet type-checks the package in dir, with its tests, and returns the
+// problems found with paths relative to the workspace root.
+func goVet(ctx context.Context, dir string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "go", "vet", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		if errors.Is(err, exec.ErrNotFound) {
+			// without a go command there is nothing to check with
+			return "", nil
+		}
+		return "", err
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "vet: ")
+		if strings.HasPrefix(line, "./") {
+			line = displayPath(filepath.Join(dir, line[2:]))
+		}
+		lines = append(lines, line)
+	}
+	return truncateOutput(strings.Join(lines, "\n"), maxCommandOutput), nil
+}
+
+func inGoModule(dir string) bool {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// confirmPostEditHooks returns the configured hooks that may run. Hooks from
+// the project's config file may have come with the repository, so they only
+// run once the user approved the file as it is now; the approval is kept in
+// trust until the file changes.
+func confirmPostEditHooks(config Config, trust *TrustStore, getUserMessage func() (string, bool)) ([]PostEditCommand, error) {
+	if config.projectFile == "" || len(config.PostEditHooks) == 0 {
+		return config.PostEditHooks, nil
+	}
+	content, err := os.ReadFile(config.projectFile)
+	if err != nil {
+		return nil, err
+	}
+	if trust.Trusted(config.projectFile, content) {
+		return config.PostEditHooks, nil
+	}
+
+	fmt.Printf("%s runs these commands on the files the agent changes:\n", config.projectFile)
+	for _, hook := range config.PostEditHooks {
+		fmt.Printf("  %s: %s\n", hook.Pattern, hook.Command)
+	}
+	fmt.Print("\u001b[95mRun these hooks?\u001b[0m [y/n]: ")
+	answer, ok := getUserMessage()
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if !ok || (answer != "y" && answer != "yes") {
+		fmt.Println("Not running them.")
+		return nil, nil
+	}
+	return config.PostEditHooks, trust.Trust(config.projectFile, content)
+}
+
+// CommandPostEditHook turns a hook from the config file into a PostEditHook.
+func CommandPostEditHook(command PostEditCommand) PostEditHook {
+	return PostEditHook{
+		Name:    command.Command,
+		Pattern: command.Pattern,
+		Run: func(ctx context.Context, paths []string) (string, error) {
+			report := []string{}
+			for _, path := range paths {
+				output, err := runHookCommand(ctx, command.Command, path)
+				if err != nil {
+					report = append(report, fmt.Sprintf("%s: %s: %s", displayPath(path), command.Command, err.Error()))
+					if output != "" {
+						report = append(report, output)
+					}
+				}
+			}
+			return strings.Join(report, "\n"), nil
+		},
+	}
+}
+
+func runHookCommand(ctx context.Context, command, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = workspace.Root()
+	cmd.Env = append(os.Environ(), "FILE="+path)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", commandTimeout)
+	}
+	return truncateOutput(strings.TrimSpace(string(output)), maxCommandOutput), err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Interrupts turns ctrl-c into cancelling what the agent is doing. The first
+// press cancels the running step, an inference or a tool call, and the agent
+// goes back to the prompt; a press with no step running, or a second press
+// while the step winds down, exits.
+type Interrupts struct {
+	mu sync.Mutex
+	// cancel cancels the running step, and is nil between steps
+	cancel    context.CancelFunc
+	cancelled bool
+	exit      func()
+}
+
+func NewInterrupts(exit func()) *Interrupts {
+	return &Interrupts{exit: exit}
+}
+
+// Listen handles the signals until the channel is closed.
+func (i *Interrupts) Listen(signals <-chan os.Signal) {
+	for range signals {
+		i.Interrupt()
+	}
+}
+
+// Interrupt cancels the running step, or exits if there is nothing left to cancel.
+func (i *Interrupts) Interrupt() {
+	i.mu.Lock()
+	cancel := i.cancel
+	first := cancel != nil && !i.cancelled
+	i.cancelled = true
+	i.mu.Unlock()
+
+	if !first {
+		i.exit()
+		return
+	}
+	fmt.Println("\n\u001b[91minterrupted\u001b[0m (press ctrl-c again to exit)")
+	cancel()
+}
+
+// Begin starts a step that the next interrupt cancels, returning its context
+// and a function to call when the step is over. A nil Interrupts never cancels.
+func (i *Interrupts) Begin(ctx context.Context) (context.Context, func()) {
+	if i == nil {
+		return ctx, func() {}
+	}
+	stepCtx, cancel := context.WithCancel(ctx)
+	i.mu.Lock()
+	i.cancel = cancel
+	i.cancelled = false
+	i.mu.Unlock()
+	return stepCtx, func() {
+		i.mu.Lock()
+		i.cancel = nil
+		i.mu.Unlock()
+		cancel()
+	}
+}
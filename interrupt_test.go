@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInterrupts(t *testing.T) {
+	exits := 0
+	interrupts := NewInterrupts(func() { exits++ })
+
+	// happy path: the first interrupt cancels the running step
+	ctx, endStep := interrupts.Begin(context.Background())
+	interrupts.Interrupt()
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("expected the step to be cancelled, got %v", ctx.Err())
+	}
+	if exits != 0 {
+		t.Fatalf("expected no exit, got %d", exits)
+	}
+
+	// test a second interrupt before the step is over exits
+	interrupts.Interrupt()
+	if exits != 1 {
+		t.Fatalf("expected an exit, got %d", exits)
+	}
+	endStep()
+
+	// test an interrupt between steps exits
+	interrupts.Interrupt()
+	if exits != 2 {
+		t.Fatalf("expected an exit, got %d", exits)
+	}
+
+	// test a new step can be cancelled again, and ending it doesn't cancel the parent
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, endStep = interrupts.Begin(parent)
+	interrupts.Interrupt()
+	endStep()
+	if ctx.Err() != context.Canceled || parent.Err() != nil || exits != 2 {
+		t.Fatalf("expected only the step to be cancelled, got %v, %v and %d exits", ctx.Err(), parent.Err(), exits)
+	}
+
+	// test a nil Interrupts never cancels
+	var none *Interrupts
+	ctx, endStep = none.Begin(parent)
+	endStep()
+	if ctx.Err() != nil {
+		t.Fatalf("expected no cancellation, got %v", ctx.Err())
+	}
+}
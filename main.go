@@ -4,18 +4,80 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/invopop/jsonschema"
 )
 
 func main() {
-	client := anthropic.NewClient()
+	configFlags := registerConfigFlags(flag.CommandLine)
+	resumeID := flag.String("resume", "", "resume the session with the given ID")
+	continueLast := flag.Bool("continue", false, "continue the most recent session")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s sessions\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	sessions := NewSessionStore(filepath.Join(projectDir, "sessions"))
+	if flag.Arg(0) == "sessions" {
+		err := printSessions(sessions)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	config, err := LoadConfig(configFlags)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *continueLast && *resumeID == "" {
+		*resumeID, err = sessions.Latest()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	var session *Session
+	if *resumeID != "" {
+		session, err = sessions.Open(*resumeID)
+	} else {
+		session, err = sessions.Create()
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	commandTimeout = time.Duration(config.CommandTimeout) * time.Second
+	diffMaxBytes = *config.DiffMaxBytes
+	gitBranch = config.GitBranch
+	workspace, err = NewWorkspace(config.WorkspaceRoot, config.AllowedDirs)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	provider, err := NewProvider(config)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
 
 	scanner := bufio.NewScanner(os.Stdin)
 	getUserMessage := func() (string, bool) {
@@ -25,34 +87,126 @@ func main() {
 		return scanner.Text(), true
 	}
 
-	tools := []ToolDefinition{ReadFileDefinition, ListFilesDefinition, EditFileDefinition, ReadLinesDefinition, GetFileLengthDefinition, DeleteLinesDefinition}
-	agent := NewAgent(&client, getUserMessage, tools)
-	err := agent.Run(context.TODO())
+	tools := []ToolDefinition{ReadFileDefinition, ListFilesDefinition, EditFileDefinition, MultiEditDefinition, ApplyPatchDefinition, SearchDefinition, GlobDefinition, GoSymbolsDefinition, GoDocDefinition, GoDefinitionDefinition, GoReferencesDefinition, RenameSymbolDefinition, RunGoTestsDefinition, GitStatusDefinition, GitDiffDefinition, GitLogDefinition, GitBlameDefinition, GitCommitDefinition, ReadLinesDefinition, GetFileLengthDefinition, DeleteLinesDefinition, RunCommandDefinition}
+	for i, tool := range tools {
+		if seconds, ok := config.ToolTimeouts[tool.Name]; ok {
+			tools[i].Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	permissions, err := LoadPermissions(filepath.Join(projectDir, "permissions.json"), UserTrustStore())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	err = confirmPermissions(permissions, getUserMessage)
+	if err != nil {
+		fmt.Printf("\u001b[91mwarning\u001b[0m: failed to remember the approval: %s\n", err.Error())
+	}
+
+	agent := NewAgent(provider, getUserMessage, tools, config, session, permissions)
+	customCommands, err := loadCustomCommands(filepath.Join(projectDir, "commands"))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	agent.RegisterCommands(customCommands)
+	if *config.PostEditGo {
+		agent.RegisterPostEditHooks(GoPostEditHook())
+	}
+	hooks, err := confirmPostEditHooks(config, UserTrustStore(), getUserMessage)
+	if err != nil {
+		fmt.Printf("\u001b[91mwarning\u001b[0m: failed to check the post-edit hooks: %s\n", err.Error())
+	}
+	for _, command := range hooks {
+		agent.RegisterPostEditHooks(CommandPostEditHook(command))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	interrupts := NewInterrupts(func() {
+		fmt.Println()
+		os.Exit(130)
+	})
+	go interrupts.Listen(signals)
+	agent.HandleInterrupts(interrupts)
+
+	err = agent.Run(context.Background())
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	}
 }
 
-func NewAgent(client *anthropic.Client, getUserMessage func() (string, bool), tools []ToolDefinition) *Agent {
+func NewAgent(provider Provider, getUserMessage func() (string, bool), tools []ToolDefinition, config Config, session *Session, permissions *Permissions) *Agent {
+	if permissions == nil {
+		permissions = &Permissions{}
+	}
+	conversation := []anthropic.MessageParam{}
+	if session != nil {
+		conversation = append(conversation, session.History()...)
+	}
 	return &Agent{
-		client:         client,
+		provider:       provider,
 		getUserMessage: getUserMessage,
 		tools:          tools,
+		config:         config,
+		session:        session,
+		conversation:   conversation,
+		commands:       builtinCommands(),
+		permissions:    permissions,
+		retryPolicy:    DefaultRetryPolicy(*config.MaxRetries),
 	}
 }
 
 type Agent struct {
-	client         *anthropic.Client
+	provider       Provider
 	getUserMessage func() (string, bool)
 	tools          []ToolDefinition
+	config         Config
+	session        *Session
+	conversation   []anthropic.MessageParam
+	commands       []SlashCommand
+	permissions    *Permissions
+	postEditHooks  []PostEditHook
+	interrupts     *Interrupts
+	retryPolicy    RetryPolicy
+	// contextTokens is the size of the context as of the last response, used to decide when to compact
+	contextTokens int64
+	// totalUsage adds up the token usage of every request in this process
+	totalUsage anthropic.Usage
 }
 
-func (a *Agent) Run(ctx context.Context) error {
-	conversation := []anthropic.MessageParam{}
+// maxContinuations caps how many times in a row the agent asks Claude to pick up
+// a response that was cut off at max_tokens before handing control back to the user.
+const maxContinuations = 3
+
+const continuationPrompt = "Your previous response was cut off because it reached the max_tokens limit. " +
+	"Continue exactly where you left off. If you were in the middle of a tool call, issue the complete tool call again."
 
-	fmt.Println("Chat with Claude (use 'ctrl-c' to quit)")
+// interruptedReply stands in for Claude's reply to a step the user interrupted.
+const interruptedReply = "(interrupted by the user)"
+
+// HandleInterrupts lets interrupts cancel the agent's inferences and tool calls.
+func (a *Agent) HandleInterrupts(interrupts *Interrupts) {
+	a.interrupts = interrupts
+}
+
+func (a *Agent) Run(ctx context.Context) error {
+	fmt.Println("Chat with Claude (use 'ctrl-c' to interrupt, 'ctrl-c' twice or /exit to quit, /help for commands)")
 
 	readUserInput := true
+	if len(a.conversation) > 0 {
+		fmt.Printf("Resumed session %s (%d messages)\n", a.session.ID, len(a.conversation))
+		if toolResults, ok := interruptedToolResults(a.conversation); ok {
+			a.addMessage(toolResults)
+		}
+		// a session that stopped in the middle of a turn picks up where it left off
+		readUserInput = a.conversation[len(a.conversation)-1].Role != anthropic.MessageParamRoleUser
+		if !readUserInput {
+			// the turn's prompt is from an earlier run, so only its file changes can be undone
+			workspace.Checkpoints().Begin("(resumed turn)", -1)
+		}
+	}
+	continuations := 0
 	for {
 		if readUserInput {
 			fmt.Print("\u001b[94mYou\u001b[0m: ")
@@ -61,93 +215,391 @@ func (a *Agent) Run(ctx context.Context) error {
 				break
 			}
 
+			if isSlashCommand(userInput) {
+				stepCtx, endStep := a.interrupts.Begin(ctx)
+				prompt, err := a.runSlashCommand(stepCtx, userInput)
+				endStep()
+				if errors.Is(err, errExit) {
+					break
+				}
+				if err != nil {
+					fmt.Printf("\u001b[91merror\u001b[0m: %s\n", err.Error())
+				}
+				if prompt == "" {
+					continue
+				}
+				userInput = prompt
+			}
+
+			// the user may have changed the code since the last turn
+			forgetGoPackages()
+			workspace.Checkpoints().Begin(userInput, len(a.conversation))
 			userMessage := anthropic.NewUserMessage(anthropic.NewTextBlock(userInput))
-			conversation = append(conversation, userMessage)
+			a.addMessage(userMessage)
+			continuations = 0
+		}
+
+		// compaction, inference and the tool calls are one step that ctrl-c cancels
+		stepCtx, endStep := a.interrupts.Begin(ctx)
+		if threshold := *a.config.CompactThreshold; threshold > 0 && a.contextTokens > threshold {
+			err := a.compact(stepCtx, compactKeepTurns)
+			if err != nil {
+				fmt.Printf("\u001b[91mwarning\u001b[0m: failed to compact conversation: %s\n", err.Error())
+			}
 		}
 
-		message, err := a.runInference(ctx, conversation)
+		message, err := a.runInference(stepCtx, a.conversation)
 		if err != nil {
-			return err
+			interrupted := stepCtx.Err() != nil && ctx.Err() == nil
+			endStep()
+			if ctx.Err() != nil {
+				return err
+			}
+			// the retries ran out or the request can't succeed, so the user decides what to do next
+			if interrupted {
+				a.closeTurn(interruptedReply)
+			} else {
+				fmt.Printf("\u001b[91merror\u001b[0m: %s\n", err.Error())
+				a.closeTurn(fmt.Sprintf("(the request failed: %s)", err.Error()))
+			}
+			readUserInput = true
+			continue
 		}
-		conversation = append(conversation, message.ToParam())
-
-		toolResults := []anthropic.ContentBlockParamUnion{}
-		for _, content := range message.Content {
-			switch content.Type {
-			case "text":
-				fmt.Printf("\u001b[93mClaude\u001b[0m: %s\n", content.Text)
-			case "tool_use":
-				result := a.executeTool(content.ID, content.Name, content.Input)
-				toolResults = append(toolResults, result)
+
+		switch message.StopReason {
+		case anthropic.StopReasonToolUse:
+			a.addMessage(message.ToParam())
+			toolResults := a.executeToolCalls(stepCtx, message)
+			a.addMessage(anthropic.NewUserMessage(toolResults...))
+			readUserInput = false
+		case anthropic.StopReasonMaxTokens:
+			fmt.Printf("\u001b[91mwarning\u001b[0m: response truncated at %d tokens (raise it with -max-tokens)\n", a.config.MaxTokens)
+			// a tool call that was cut off has incomplete input, so it can't be run
+			// and must not be left in the history without a matching tool_result
+			dropTruncatedToolUse(message)
+			if len(message.Content) > 0 {
+				a.addMessage(message.ToParam())
+			} else {
+				// nothing is left of the response, but the turn still needs Claude's side
+				a.addMessage(anthropic.NewAssistantMessage(anthropic.NewTextBlock("(the response was cut off in the middle of a tool call)")))
+			}
+			if continuations >= maxContinuations {
+				fmt.Printf("\u001b[91mwarning\u001b[0m: giving up after %d continuations\n", continuations)
+				// the complete tool calls in the message aren't run, but still need results
+				toolResults := []anthropic.ContentBlockParamUnion{}
+				for _, block := range message.Content {
+					if block.Type == "tool_use" {
+						toolResults = append(toolResults, anthropic.NewToolResultBlock(block.ID, "tool call was not run because the response was cut off", true))
+					}
+				}
+				if len(toolResults) > 0 {
+					a.addMessage(anthropic.NewUserMessage(toolResults...))
+				}
+				a.closeTurn(fmt.Sprintf("(gave up after %d continuations)", continuations))
+				readUserInput = true
+				break
 			}
+			continuations++
+			// any complete tool calls before the cut still need their results
+			toolResults := a.executeToolCalls(stepCtx, message)
+			toolResults = append(toolResults, anthropic.NewTextBlock(continuationPrompt))
+			a.addMessage(anthropic.NewUserMessage(toolResults...))
+			readUserInput = false
+		case anthropic.StopReasonPauseTurn:
+			// the turn was paused mid-way; sending the conversation back lets Claude resume it
+			a.addMessage(message.ToParam())
+			readUserInput = false
+		case anthropic.StopReasonRefusal:
+			a.addMessage(message.ToParam())
+			fmt.Println("\u001b[91mwarning\u001b[0m: Claude declined to respond to this request")
+			readUserInput = true
+		default:
+			// end_turn and stop_sequence: Claude is done, hand control back to the user
+			a.addMessage(message.ToParam())
+			readUserInput = true
 		}
-		if len(toolResults) == 0 {
+
+		if stepCtx.Err() != nil && ctx.Err() == nil {
+			// interrupted tool calls already have their results, so only Claude's reply can be missing
+			a.closeTurn(interruptedReply)
 			readUserInput = true
-			continue
 		}
-		readUserInput = false
-		conversation = append(conversation, anthropic.NewUserMessage(toolResults...))
+		endStep()
 	}
 
 	return nil
 }
 
+// closeTurn gives a turn that Claude couldn't finish a stand-in reply if it
+// needs one, so the conversation still alternates between the user and Claude.
+func (a *Agent) closeTurn(reply string) {
+	if len(a.conversation) > 0 && a.conversation[len(a.conversation)-1].Role == anthropic.MessageParamRoleUser {
+		a.addMessage(anthropic.NewAssistantMessage(anthropic.NewTextBlock(reply)))
+	}
+}
+
+// addMessage appends a message to the conversation and records it in the session.
+func (a *Agent) addMessage(message anthropic.MessageParam) {
+	a.conversation = append(a.conversation, message)
+	if a.session == nil {
+		return
+	}
+	err := a.session.Append(message)
+	if err != nil {
+		fmt.Printf("\u001b[91mwarning\u001b[0m: failed to save session: %s\n", err.Error())
+	}
+}
+
+// maxParallelTools caps how many read-only tool calls run at the same time.
+const maxParallelTools = 8
+
+// executeToolCalls runs every tool_use block in the message and returns the tool results in order.
+// Text blocks were already printed while streaming, so only tool calls are left to handle.
+//
+// Read-only calls run concurrently. Mutating calls run one at a time, and the
+// read-only calls before and after one don't run at the same time as it, so
+// every call sees the files as the calls before it left them.
+func (a *Agent) executeToolCalls(ctx context.Context, message *anthropic.Message) []anthropic.ContentBlockParamUnion {
+	calls := []anthropic.ContentBlockUnion{}
+	for _, content := range message.Content {
+		if content.Type == "tool_use" {
+			calls = append(calls, content)
+		}
+	}
+
+	toolResults := make([]anthropic.ContentBlockParamUnion, len(calls))
+	for start := 0; start < len(calls); {
+		if tool, ok := a.findTool(calls[start].Name); ok && tool.Mutating {
+			toolResults[start] = a.executeTool(ctx, calls[start].ID, calls[start].Name, calls[start].Input)
+			start++
+			continue
+		}
+		end := start + 1
+		for end < len(calls) {
+			if tool, ok := a.findTool(calls[end].Name); ok && tool.Mutating {
+				break
+			}
+			end++
+		}
+		a.executeConcurrently(ctx, calls[start:end], toolResults[start:end])
+		start = end
+	}
+	return toolResults
+}
+
+// executeConcurrently runs read-only tool calls in up to maxParallelTools
+// goroutines, storing each call's result at the same index in results.
+func (a *Agent) executeConcurrently(ctx context.Context, calls []anthropic.ContentBlockUnion, results []anthropic.ContentBlockParamUnion) {
+	if len(calls) == 1 {
+		results[0] = a.executeTool(ctx, calls[0].ID, calls[0].Name, calls[0].Input)
+		return
+	}
+
+	slots := make(chan struct{}, maxParallelTools)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = a.executeTool(ctx, call.ID, call.Name, call.Input)
+		}()
+	}
+	wg.Wait()
+}
+
+// dropTruncatedToolUse removes the final content block from a message that hit
+// max_tokens if it is a tool call, since its input JSON may be incomplete.
+func dropTruncatedToolUse(message *anthropic.Message) {
+	if len(message.Content) == 0 {
+		return
+	}
+	if message.Content[len(message.Content)-1].Type == "tool_use" {
+		message.Content = message.Content[:len(message.Content)-1]
+	}
+}
+
+// runInference sends the conversation to Claude, retrying failures that may
+// go away, and returns the response.
 func (a *Agent) runInference(ctx context.Context, conversation []anthropic.MessageParam) (*anthropic.Message, error) {
-	anthropicTools := []anthropic.ToolUnionParam{}
-	// Loop over the tools on the agent and convert them to Anthropic tools
-	for _, tool := range a.tools {
-		anthropicTools = append(anthropicTools, anthropic.ToolUnionParam{
-			OfTool: &anthropic.ToolParam{
-				Name:        tool.Name,
-				Description: anthropic.String(tool.Description),
-				InputSchema: tool.InputSchema,
-			},
-		})
-	}
-
-	message, err := a.client.Messages.New(ctx, anthropic.MessageNewParams{
-		Model:     anthropic.ModelClaude3_7SonnetLatest,
-		MaxTokens: int64(1024),
-		Messages:  conversation,   // Use the current conversation (entire history)
-		Tools:     anthropicTools, // Add the tools to the request
+	var message *anthropic.Message
+	err := a.retryPolicy.Do(ctx, func() error {
+		var err error
+		message, err = a.streamMessage(ctx, conversation)
+		return err
 	})
-	return message, err
+	if err != nil {
+		return nil, err
+	}
+
+	a.recordUsage(message.Usage)
+	a.contextTokens = message.Usage.InputTokens + message.Usage.CacheCreationInputTokens + message.Usage.CacheReadInputTokens + message.Usage.OutputTokens
+
+	return message, nil
 }
 
-func (a *Agent) executeTool(id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
-	var toolDef ToolDefinition
-	var found bool
-	// find the tool definition for the tool we want to execute on the agent
+// streamMessage makes one request for the conversation, printing the
+// response's text as it streams in.
+func (a *Agent) streamMessage(ctx context.Context, conversation []anthropic.MessageParam) (*anthropic.Message, error) {
+	printingText, printedText := false, false
+	message, err := a.provider.Stream(ctx, a.inferenceRequest(conversation), func(event StreamEvent) {
+		switch event.Type {
+		case StreamTextStart:
+			fmt.Print("\u001b[93mClaude\u001b[0m: ")
+			printingText, printedText = true, true
+		case StreamTextDelta:
+			fmt.Print(event.Text)
+		case StreamTextStop:
+			fmt.Println()
+			printingText = false
+		}
+	})
+	if err != nil {
+		// end the text cut off by the error, so what comes next starts on its own line
+		if printingText {
+			fmt.Println()
+		}
+		// a retry streams the response again, so the text so far doesn't count
+		if printedText {
+			fmt.Println("\u001b[91m(the response above was cut off and discarded)\u001b[0m")
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+func (a *Agent) recordUsage(usage anthropic.Usage) {
+	a.totalUsage.InputTokens += usage.InputTokens
+	a.totalUsage.OutputTokens += usage.OutputTokens
+	a.totalUsage.CacheCreationInputTokens += usage.CacheCreationInputTokens
+	a.totalUsage.CacheReadInputTokens += usage.CacheReadInputTokens
+}
+
+// inferenceRequest builds the request for the given conversation from the agent's tools and configuration.
+func (a *Agent) inferenceRequest(conversation []anthropic.MessageParam) InferenceRequest {
+	return InferenceRequest{
+		Model:         a.config.Model,
+		System:        a.config.SystemPrompt,
+		Messages:      conversation, // Use the current conversation (entire history)
+		Tools:         a.tools,
+		MaxTokens:     a.config.MaxTokens,
+		Temperature:   a.config.Temperature,
+		StopSequences: a.config.StopSequences,
+	}
+}
+
+// findTool returns the agent's tool with the given name.
+func (a *Agent) findTool(name string) (ToolDefinition, bool) {
 	for _, tool := range a.tools {
 		if tool.Name == name {
-			toolDef = tool
-			found = true
-			break
+			return tool, true
 		}
 	}
+	return ToolDefinition{}, false
+}
+
+// executeTool runs one tool call. Read-only tools may run concurrently, so
+// only mutating tools may touch the agent's or the workspace's state.
+func (a *Agent) executeTool(ctx context.Context, id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
+	// find the tool definition for the tool we want to execute on the agent
+	toolDef, found := a.findTool(name)
 	if !found {
 		// if the tool is not found, return a tool result block with an error message
 		return anthropic.NewToolResultBlock(id, "tool not found", true)
 	}
 
+	if ctx.Err() != nil {
+		return anthropic.NewToolResultBlock(id, "tool call was interrupted by the user before it ran", true)
+	}
+
+	// models, local ones in particular, sometimes send arguments that aren't valid JSON
+	if !isJSONObject(input) {
+		return anthropic.NewToolResultBlock(id, fmt.Sprintf("invalid tool input: the arguments must be a JSON object, got %s", input), true)
+	}
+
 	// print the tool name and input to the console (we're calling it)
 	fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s)\n", name, input)
 
-	// call the tool function with the input
-	response, err := toolDef.Function(input)
+	if !toolDef.Mutating {
+		// call the tool function with the input
+		response, err := callTool(ctx, toolDef, input)
+		if err != nil {
+			// if the tool function returns an error, return a tool result block with the error message
+			return anthropic.NewToolResultBlock(id, err.Error(), true)
+		}
+		return anthropic.NewToolResultBlock(id, response, false)
+	}
+
+	allowed, reason := a.checkPermission(ctx, name, input)
+	if !allowed {
+		return anthropic.NewToolResultBlock(id, reason, true)
+	}
+
+	if ctx.Err() != nil {
+		return anthropic.NewToolResultBlock(id, "tool call was interrupted by the user before it ran", true)
+	}
+
+	// the call may change the code, e.g. by editing a file or running a generator
+	defer forgetGoPackages()
+	workspace.TakeWritten()
+	response, err := callTool(ctx, toolDef, input)
 	if err != nil {
-		// if the tool function returns an error, return a tool result block with the error message
 		return anthropic.NewToolResultBlock(id, err.Error(), true)
 	}
+	// let the post-edit hooks check the files the tool changed, leaving out the
+	// hooks' own writes, e.g. formatting
+	if report := a.runPostEditHooks(ctx, workspace.TakeWritten()); report != "" {
+		workspace.TakeWritten()
+		fmt.Println(report)
+		response += "\n\n" + report
+	}
 	// if the tool function returns a response, return a tool result block with the response
 	return anthropic.NewToolResultBlock(id, response, false)
 }
 
+// isJSONObject reports whether input is a JSON object, as tool arguments must be.
+func isJSONObject(input json.RawMessage) bool {
+	object := map[string]json.RawMessage{}
+	return json.Unmarshal(input, &object) == nil
+}
+
+// defaultToolTimeout is how long a tool call may run when its tool doesn't set a timeout.
+const defaultToolTimeout = 5 * time.Minute
+
+// callTool calls the tool's function with a context that is cancelled when the
+// tool's timeout runs out, and explains errors caused by the timeout or by the
+// user interrupting.
+func callTool(ctx context.Context, tool ToolDefinition, input json.RawMessage) (string, error) {
+	timeout := tool.Timeout
+	if timeout == 0 {
+		timeout = defaultToolTimeout
+	}
+	toolCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := tool.Function(toolCtx, input)
+	switch {
+	case err == nil:
+		return response, nil
+	case ctx.Err() != nil:
+		return "", fmt.Errorf("tool call was interrupted by the user")
+	case toolCtx.Err() == context.DeadlineExceeded:
+		return "", fmt.Errorf("tool call timed out after %s", timeout)
+	}
+	return "", err
+}
+
 type ToolDefinition struct {
 	Name        string                         `json:"name"`
 	Description string                         `json:"description"`
 	InputSchema anthropic.ToolInputSchemaParam `json:"input_schema"`
-	Function    func(input json.RawMessage) (string, error)
+	// Function runs the tool. It should stop early and return an error when ctx is done.
+	Function func(ctx context.Context, input json.RawMessage) (string, error)
+	// Mutating tools change files or run commands, so they go through the permission check
+	Mutating bool `json:"-"`
+	// Timeout is how long a call may run before its context is cancelled. Zero means defaultToolTimeout.
+	Timeout time.Duration `json:"-"`
 }
 
 var ReadFileDefinition = ToolDefinition{
@@ -163,14 +615,19 @@ type ReadFileInput struct {
 
 var ReadFileInputSchema = GenerateSchema[ReadFileInput]()
 
-func ReadFile(input json.RawMessage) (string, error) {
+func ReadFile(ctx context.Context, input json.RawMessage) (string, error) {
 	readFileInput := ReadFileInput{}
 	err := json.Unmarshal(input, &readFileInput)
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+
+	path, err := workspace.Resolve(readFileInput.Path)
+	if err != nil {
+		return "", err
 	}
 
-	content, err := os.ReadFile(readFileInput.Path)
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
@@ -183,62 +640,149 @@ func ReadFile(input json.RawMessage) (string, error) {
 	return strings.Join(lines, "\n"), nil
 }
 
+// defaultListFilesLimit is how many entries list_files returns when the model
+// doesn't ask for a different limit.
+const defaultListFilesLimit = 200
+
 var ListFilesDefinition = ToolDefinition{
-	Name:        "list_files",
-	Description: "List files and directories at a given path. If no path is provided, list files in the current directory.",
+	Name: "list_files",
+	Description: `List files and directories at a given path. If no path is provided, list files in the current directory.
+
+Returns a tree with one entry per line, indented by depth. Directories end with '/' and files show their size.
+
+Hidden files and files excluded by .gitignore are left out unless asked for, and .git directories are never listed. Use 'max_depth' to limit how deep the listing goes and 'offset'/'limit' to page through long listings.
+`,
 	InputSchema: ListFilesInputSchema,
 	Function:    ListFiles,
 }
 
 type ListFilesInput struct {
-	Path string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	Path             string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	MaxDepth         int    `json:"max_depth,omitempty" jsonschema_description:"Optional maximum depth to list, where 1 lists only the entries directly in the path. Defaults to no limit."`
+	IncludeHidden    bool   `json:"include_hidden,omitempty" jsonschema_description:"Include files and directories whose names start with a dot"`
+	RespectGitignore *bool  `json:"respect_gitignore,omitempty" jsonschema_description:"Leave out files excluded by .gitignore. Defaults to true."`
+	Offset           int    `json:"offset,omitempty" jsonschema_description:"Number of entries to skip, for paging through long listings"`
+	Limit            int    `json:"limit,omitempty" jsonschema_description:"Maximum number of entries to return. Defaults to 200."`
 }
 
 var ListFilesInputSchema = GenerateSchema[ListFilesInput]()
 
-func ListFiles(input json.RawMessage) (string, error) {
+func ListFiles(ctx context.Context, input json.RawMessage) (string, error) {
 	listFilesInput := ListFilesInput{}
 	err := json.Unmarshal(input, &listFilesInput)
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+
+	if listFilesInput.MaxDepth < 0 || listFilesInput.Offset < 0 || listFilesInput.Limit < 0 {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	limit := listFilesInput.Limit
+	if limit == 0 {
+		limit = defaultListFilesLimit
 	}
+	respectGitignore := listFilesInput.RespectGitignore == nil || *listFilesInput.RespectGitignore
 
-	dir := "."
-	if listFilesInput.Path != "" {
-		dir = listFilesInput.Path
+	dir, err := workspace.Resolve(listFilesInput.Path)
+	if err != nil {
+		return "", err
 	}
 
-	var files []string
-	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	var entries []string
+	unreadable := []string{}
+	err = walkFiles(dir, respectGitignore, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
-			return err
+			if path == dir {
+				return err
+			}
+			unreadable = append(unreadable, filepath.ToSlash(workspace.Rel(path)))
+			return skipWalkError(d)
 		}
 
 		relPath, err := filepath.Rel(dir, path)
 		if err != nil {
 			return err
 		}
+		if relPath == "." {
+			return nil
+		}
 
-		if relPath != "." {
-			if info.IsDir() {
-				files = append(files, relPath+"/")
-			} else {
-				files = append(files, relPath)
+		if !listFilesInput.IncludeHidden && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		depth := strings.Count(relPath, string(filepath.Separator)) + 1
+		indent := strings.Repeat("  ", depth-1)
+		if d.IsDir() {
+			if listFilesInput.MaxDepth > 0 && depth >= listFilesInput.MaxDepth {
+				children, err := os.ReadDir(path)
+				if err != nil {
+					unreadable = append(unreadable, filepath.ToSlash(workspace.Rel(path)))
+					entries = append(entries, fmt.Sprintf("%s%s/ (not expanded)", indent, d.Name()))
+					return filepath.SkipDir
+				}
+				count := fmt.Sprintf("%d entries", len(children))
+				if len(children) == 1 {
+					count = "1 entry"
+				}
+				entries = append(entries, fmt.Sprintf("%s%s/ (%s, not expanded)", indent, d.Name(), count))
+				return filepath.SkipDir
 			}
+			entries = append(entries, fmt.Sprintf("%s%s/", indent, d.Name()))
+			return nil
 		}
 
+		info, err := d.Info()
+		if err != nil {
+			// the file was removed while walking
+			return nil
+		}
+		entries = append(entries, fmt.Sprintf("%s%s (%s)", indent, d.Name(), formatSize(info.Size())))
 		return nil
 	})
 	if err != nil {
 		return "", err
 	}
 
-	result, err := json.Marshal(files)
-	if err != nil {
-		return "", err
+	if len(entries) == 0 {
+		return "(empty directory)", nil
+	}
+	note := walkErrorNote(unreadable)
+	if listFilesInput.Offset >= len(entries) {
+		return "", fmt.Errorf("offset %d is past the last of the %d entries", listFilesInput.Offset, len(entries))
 	}
 
-	return string(result), nil
+	end := min(listFilesInput.Offset+limit, len(entries))
+	result := strings.Join(entries[listFilesInput.Offset:end], "\n") + "\n"
+	if listFilesInput.Offset > 0 || end < len(entries) {
+		result += fmt.Sprintf("(showing entries %d-%d of %d", listFilesInput.Offset+1, end, len(entries))
+		if end < len(entries) {
+			result += fmt.Sprintf("; use offset %d to see more", end)
+		}
+		result += ")\n"
+	}
+	return result + note, nil
+}
+
+// formatSize formats a file size in bytes for people, e.g. "1.5 KB".
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	units := []string{"KB", "MB", "GB", "TB"}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
 }
 
 var EditFileDefinition = ToolDefinition{
@@ -253,6 +797,7 @@ There MUST only be one match for 'old_str' in the file.
 `,
 	InputSchema: EditFileInputSchema,
 	Function:    EditFile,
+	Mutating:    true,
 }
 
 type EditFileInput struct {
@@ -263,7 +808,7 @@ type EditFileInput struct {
 
 var EditFileInputSchema = GenerateSchema[EditFileInput]()
 
-func EditFile(input json.RawMessage) (string, error) {
+func EditFile(ctx context.Context, input json.RawMessage) (string, error) {
 	editFileInput := EditFileInput{}
 	err := json.Unmarshal(input, &editFileInput)
 	if err != nil {
@@ -274,10 +819,15 @@ func EditFile(input json.RawMessage) (string, error) {
 		return "", fmt.Errorf("invalid input parameters")
 	}
 
-	content, err := os.ReadFile(editFileInput.Path)
+	path, err := workspace.Resolve(editFileInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) && editFileInput.OldStr == "" {
-			return createNewFile(editFileInput.Path, editFileInput.NewStr)
+			return createNewFile(path, editFileInput.NewStr)
 		}
 		return "", err
 	}
@@ -289,29 +839,29 @@ func EditFile(input json.RawMessage) (string, error) {
 
 	newContent := strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, -1)
 
-	err = os.WriteFile(editFileInput.Path, []byte(newContent), 0644)
+	err = workspace.WriteFile(path, []byte(newContent))
 	if err != nil {
 		return "", err
 	}
 
-	return "OK", nil
+	return reportEdit(path, oldContent, newContent), nil
 }
 
 func createNewFile(filePath, content string) (string, error) {
 	dir := path.Dir(filePath)
 	if dir != "." {
-		err := os.MkdirAll(dir, 0755)
+		err := workspace.MkdirAll(dir)
 		if err != nil {
 			return "", fmt.Errorf("failed to create directory: %w", err)
 		}
 	}
 
-	err := os.WriteFile(filePath, []byte(content), 0644)
+	err := workspace.WriteFile(filePath, []byte(content))
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
 
-	return fmt.Sprintf("Successfully created file %s", filePath), nil
+	return fmt.Sprintf("Successfully created file %s", workspace.Rel(filePath)), nil
 }
 
 var ReadLinesDefinition = ToolDefinition{
@@ -340,7 +890,7 @@ type ReadLinesInput struct {
 
 var ReadLinesInputSchema = GenerateSchema[ReadLinesInput]()
 
-func ReadLines(input json.RawMessage) (string, error) {
+func ReadLines(ctx context.Context, input json.RawMessage) (string, error) {
 	readLinesInput := ReadLinesInput{}
 	err := json.Unmarshal(input, &readLinesInput)
 	if err != nil {
@@ -355,7 +905,12 @@ func ReadLines(input json.RawMessage) (string, error) {
 		return "[]", nil
 	}
 
-	file, err := os.Open(readLinesInput.Path)
+	path, err := workspace.Resolve(readLinesInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -408,7 +963,7 @@ type GetFileLengthInput struct {
 
 var GetFileLengthInputSchema = GenerateSchema[GetFileLengthInput]()
 
-func GetFileLength(input json.RawMessage) (string, error) {
+func GetFileLength(ctx context.Context, input json.RawMessage) (string, error) {
 	getFileLengthInput := GetFileLengthInput{}
 	err := json.Unmarshal(input, &getFileLengthInput)
 	if err != nil {
@@ -419,7 +974,7 @@ func GetFileLength(input json.RawMessage) (string, error) {
 		return "", fmt.Errorf("invalid input parameters")
 	}
 
-	fileContent, err := ReadFile(json.RawMessage(`{"path": "` + getFileLengthInput.Path + `"}`))
+	fileContent, err := ReadFile(ctx, json.RawMessage(`{"path": "`+getFileLengthInput.Path+`"}`))
 	if err != nil {
 		return "", err
 	}
@@ -438,6 +993,7 @@ var DeleteLinesDefinition = ToolDefinition{
 	`,
 	InputSchema: DeleteLinesInputSchema,
 	Function:    DeleteLines,
+	Mutating:    true,
 }
 
 type DeleteLinesInput struct {
@@ -448,7 +1004,7 @@ type DeleteLinesInput struct {
 
 var DeleteLinesInputSchema = GenerateSchema[DeleteLinesInput]()
 
-func DeleteLines(input json.RawMessage) (string, error) {
+func DeleteLines(ctx context.Context, input json.RawMessage) (string, error) {
 	deleteLinesInput := DeleteLinesInput{}
 	err := json.Unmarshal(input, &deleteLinesInput)
 	if err != nil {
@@ -460,7 +1016,12 @@ func DeleteLines(input json.RawMessage) (string, error) {
 	}
 
 	// get the length of the file
-	fileContent, err := os.ReadFile(deleteLinesInput.Path)
+	path, err := workspace.Resolve(deleteLinesInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	fileContent, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
@@ -477,12 +1038,12 @@ func DeleteLines(input json.RawMessage) (string, error) {
 
 	newContent := strings.Join(lines, "\n")
 
-	err = os.WriteFile(deleteLinesInput.Path, []byte(newContent), 0644)
+	err = workspace.WriteFile(path, []byte(newContent))
 	if err != nil {
 		return "", err
 	}
 
-	return "OK", nil
+	return reportEdit(path, fileContentStr, newContent), nil
 }
 
 func GenerateSchema[T any]() anthropic.ToolInputSchemaParam {
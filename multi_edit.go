@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var MultiEditDefinition = ToolDefinition{
+	Name: "multi_edit",
+	Description: `Make several edits to one text file in a single call.
+
+Applies the 'edits' in order, each replacing its 'old_str' with its 'new_str'. Every edit sees the file as changed by the edits before it, and its 'old_str' MUST match exactly once at that point.
+
+The file is only written if every edit succeeds. If any edit fails, nothing is changed and the error says which edit failed and why.
+
+Use this instead of several 'edit_file' calls when changing many places in the same file.
+`,
+	InputSchema: MultiEditInputSchema,
+	Function:    MultiEdit,
+	Mutating:    true,
+}
+
+type MultiEditInput struct {
+	Path  string          `json:"path" jsonschema_description:"The path to the file"`
+	Edits []EditOperation `json:"edits" jsonschema_description:"The replacements to apply, in order"`
+}
+
+type EditOperation struct {
+	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly and must only have one match exactly"`
+	NewStr string `json:"new_str" jsonschema_description:"Text to replace 'old_str' with"`
+}
+
+var MultiEditInputSchema = GenerateSchema[MultiEditInput]()
+
+func MultiEdit(ctx context.Context, input json.RawMessage) (string, error) {
+	multiEditInput := MultiEditInput{}
+	err := json.Unmarshal(input, &multiEditInput)
+	if err != nil {
+		return "", err
+	}
+
+	if multiEditInput.Path == "" || len(multiEditInput.Edits) == 0 {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+
+	path, err := workspace.Resolve(multiEditInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	oldContent := string(content)
+	newContent, err := applyEdits(oldContent, multiEditInput.Edits)
+	if err != nil {
+		return "", fmt.Errorf("%w; no changes were made", err)
+	}
+
+	err = workspace.WriteFile(path, []byte(newContent))
+	if err != nil {
+		return "", err
+	}
+
+	return reportEdit(path, oldContent, newContent), nil
+}
+
+// applyEdits applies the replacements to content in order, checking each one
+// against the content as left by the ones before it.
+func applyEdits(content string, edits []EditOperation) (string, error) {
+	for i, edit := range edits {
+		if edit.OldStr == "" {
+			return "", fmt.Errorf("edit %d of %d failed: old_str must not be empty", i+1, len(edits))
+		}
+		if edit.OldStr == edit.NewStr {
+			return "", fmt.Errorf("edit %d of %d failed: old_str and new_str must be different", i+1, len(edits))
+		}
+		count := strings.Count(content, edit.OldStr)
+		if count == 0 {
+			return "", fmt.Errorf("edit %d of %d failed: old_str was not found in the file", i+1, len(edits))
+		}
+		if count > 1 {
+			return "", fmt.Errorf("edit %d of %d failed: old_str matches %d times, it must match exactly once", i+1, len(edits), count)
+		}
+		content = strings.Replace(content, edit.OldStr, edit.NewStr, 1)
+	}
+	return content, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMultiEdit(t *testing.T) {
+	// create a test file for editing
+	os.WriteFile("test_multi_edit.txt", []byte("func a() {}\nfunc b() {}\nfunc c() {}\n"), 0644)
+	defer os.Remove("test_multi_edit.txt")
+
+	// happy path: later edits see the result of earlier ones
+	multiEditInput := json.RawMessage(`{
+		"path": "test_multi_edit.txt",
+		"edits": [
+			{"old_str": "func a()", "new_str": "func alpha()"},
+			{"old_str": "func alpha() {}", "new_str": "func alpha() { b() }"},
+			{"old_str": "func c() {}\n", "new_str": ""}
+		]
+	}`)
+	result, err := MultiEdit(context.Background(), multiEditInput)
+	if err != nil {
+		t.Fatalf("failed to edit file: %v", err)
+	}
+	if !strings.HasPrefix(result, "OK\n") {
+		t.Fatalf("expected OK, got %s", result)
+	}
+	fileContent, err := os.ReadFile("test_multi_edit.txt")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(fileContent) != "func alpha() { b() }\nfunc b() {}\n" {
+		t.Fatalf("unexpected file content: %s", string(fileContent))
+	}
+
+	// test a failing edit leaves the file untouched and names the edit
+	multiEditInput = json.RawMessage(`{
+		"path": "test_multi_edit.txt",
+		"edits": [
+			{"old_str": "func b()", "new_str": "func beta()"},
+			{"old_str": "func", "new_str": "fn"}
+		]
+	}`)
+	_, err = MultiEdit(context.Background(), multiEditInput)
+	if err == nil || !strings.Contains(err.Error(), "edit 2 of 2 failed") {
+		t.Fatalf("expected edit 2 to fail, got %v", err)
+	}
+	fileContent, err = os.ReadFile("test_multi_edit.txt")
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(fileContent) != "func alpha() { b() }\nfunc b() {}\n" {
+		t.Fatalf("expected the file to be unchanged, got %s", string(fileContent))
+	}
+
+	// test an edit that no longer matches after an earlier one
+	multiEditInput = json.RawMessage(`{
+		"path": "test_multi_edit.txt",
+		"edits": [
+			{"old_str": "func b()", "new_str": "func beta()"},
+			{"old_str": "func b()", "new_str": "func gamma()"}
+		]
+	}`)
+	_, err = MultiEdit(context.Background(), multiEditInput)
+	if err == nil || !strings.Contains(err.Error(), "edit 2 of 2 failed: old_str was not found") {
+		t.Fatalf("expected edit 2 to fail, got %v", err)
+	}
+
+	// test no edits
+	multiEditInput = json.RawMessage(`{
+		"path": "test_multi_edit.txt",
+		"edits": []
+	}`)
+	_, err = MultiEdit(context.Background(), multiEditInput)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
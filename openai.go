@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// defaultOpenAIBaseURL is where llama.cpp's server listens by default. Ollama
+// serves its OpenAI-compatible API at http://localhost:11434/v1.
+const defaultOpenAIBaseURL = "http://localhost:8080/v1"
+
+// OpenAIProvider talks to an OpenAI-compatible chat completions API, as
+// served by llama.cpp, Ollama, vLLM and others, so the agent can run on a
+// local model.
+type OpenAIProvider struct {
+	baseURL string
+	// apiKey is sent as a bearer token if it isn't empty; local servers usually don't need one
+	apiKey string
+	client *http.Client
+}
+
+func NewOpenAIProvider(baseURL, apiKey string) *OpenAIProvider {
+	return &OpenAIProvider{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, client: &http.Client{}}
+}
+
+type openAIRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Tools         []openAITool         `json:"tools,omitempty"`
+	ToolChoice    string               `json:"tool_choice,omitempty"`
+	MaxTokens     int64                `json:"max_tokens,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	Stop          []string             `json:"stop,omitempty"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	// Index tells the tool calls in a streamed response apart
+	Index    int                `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+type openAIChunk struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+	} `json:"usage"`
+	Error json.RawMessage `json:"error"`
+}
+
+func (p *OpenAIProvider) Stream(ctx context.Context, request InferenceRequest, onEvent func(StreamEvent)) (*anthropic.Message, error) {
+	if onEvent == nil {
+		onEvent = func(StreamEvent) {}
+	}
+	body, err := json.Marshal(openAIRequestFor(request))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept", "text/event-stream")
+	if p.apiKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	response, err := p.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		errorBody, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+		return nil, &APIError{StatusCode: response.StatusCode, Header: response.Header, Body: strings.TrimSpace(string(errorBody))}
+	}
+
+	id := ""
+	done := false
+	text := strings.Builder{}
+	toolCalls := map[int]*openAIToolCall{}
+	finishReason := ""
+	var inputTokens, outputTokens int64
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		chunk := openAIChunk{}
+		err = json.Unmarshal([]byte(data), &chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the stream: %w", err)
+		}
+		if len(chunk.Error) > 0 {
+			return nil, fmt.Errorf("received error while streaming: %s", chunk.Error)
+		}
+		if chunk.ID != "" {
+			id = chunk.ID
+		}
+		if chunk.Usage != nil {
+			inputTokens, outputTokens = chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				if text.Len() == 0 {
+					onEvent(StreamEvent{Type: StreamTextStart})
+				}
+				text.WriteString(choice.Delta.Content)
+				onEvent(StreamEvent{Type: StreamTextDelta, Text: choice.Delta.Content})
+			}
+			// a tool call's id and name come first, and its arguments in pieces after them
+			for _, delta := range choice.Delta.ToolCalls {
+				call, ok := toolCalls[delta.Index]
+				if !ok {
+					call = &openAIToolCall{Index: delta.Index}
+					toolCalls[delta.Index] = call
+				}
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				call.Function.Name += delta.Function.Name
+				call.Function.Arguments += delta.Function.Arguments
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !done && finishReason == "" {
+		// the connection closed in the middle of the response, so try it again
+		return nil, fmt.Errorf("the stream ended before the response was complete: %w", io.ErrUnexpectedEOF)
+	}
+	if text.Len() > 0 {
+		onEvent(StreamEvent{Type: StreamTextStop})
+	}
+
+	return openAIResponseMessage(id, request.Model, text.String(), toolCalls, finishReason, inputTokens, outputTokens)
+}
+
+// openAIRequestFor translates the request into a streaming chat completions request.
+func openAIRequestFor(request InferenceRequest) openAIRequest {
+	messages := []openAIMessage{}
+	if request.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: request.System})
+	}
+	for _, message := range request.Messages {
+		messages = append(messages, openAIMessages(message)...)
+	}
+
+	tools := []openAITool{}
+	for _, tool := range request.Tools {
+		tools = append(tools, openAITool{
+			Type: "function",
+			Function: openAIFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  map[string]any{"type": "object", "properties": tool.InputSchema.Properties, "required": tool.InputSchema.Required},
+			},
+		})
+	}
+
+	openAIRequest := openAIRequest{
+		Model:         request.Model,
+		Messages:      messages,
+		Tools:         tools,
+		MaxTokens:     request.MaxTokens,
+		Temperature:   request.Temperature,
+		Stop:          request.StopSequences,
+		Stream:        true,
+		StreamOptions: &openAIStreamOptions{IncludeUsage: true},
+	}
+	if request.NoToolUse && len(tools) > 0 {
+		openAIRequest.ToolChoice = "none"
+	}
+	return openAIRequest
+}
+
+// openAIMessages translates a message. Tool results become messages of
+// their own, which have to come right after the assistant's tool calls, so
+// they go before any text in the same user message.
+func openAIMessages(message anthropic.MessageParam) []openAIMessage {
+	messages := []openAIMessage{}
+	text := []string{}
+	toolCalls := []openAIToolCall{}
+	for _, block := range message.Content {
+		switch {
+		case block.OfText != nil:
+			text = append(text, block.OfText.Text)
+		case block.OfToolUse != nil:
+			toolCalls = append(toolCalls, openAIToolCall{
+				ID:       block.OfToolUse.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: block.OfToolUse.Name, Arguments: toolCallArguments(block.OfToolUse.Input)},
+			})
+		case block.OfToolResult != nil:
+			result := []string{}
+			for _, content := range block.OfToolResult.Content {
+				if content.OfText != nil {
+					result = append(result, content.OfText.Text)
+				}
+			}
+			content := strings.Join(result, "\n")
+			if block.OfToolResult.IsError.Value {
+				content = "error: " + content
+			}
+			messages = append(messages, openAIMessage{Role: "tool", ToolCallID: block.OfToolResult.ToolUseID, Content: content})
+		}
+	}
+	if len(text) > 0 || len(toolCalls) > 0 {
+		messages = append(messages, openAIMessage{Role: string(message.Role), Content: strings.Join(text, "\n"), ToolCalls: toolCalls})
+	}
+	return messages
+}
+
+// toolCallArguments turns a tool call's input back into the arguments the
+// model sent. Arguments that weren't valid JSON were kept as a string (see
+// openAIResponseMessage), which goes back as it was rather than encoded again.
+func toolCallArguments(input any) string {
+	if raw, ok := input.(json.RawMessage); ok {
+		err := json.Unmarshal(raw, &input)
+		if err != nil {
+			return string(raw)
+		}
+	}
+	if arguments, ok := input.(string); ok {
+		return arguments
+	}
+	arguments, err := json.Marshal(input)
+	if err != nil {
+		return "{}"
+	}
+	return string(arguments)
+}
+
+// openAIResponseMessage builds the response in the Anthropic message format
+// by way of its JSON, which is how the SDK's content blocks get filled in.
+func openAIResponseMessage(id, model, text string, toolCalls map[int]*openAIToolCall, finishReason string, inputTokens, outputTokens int64) (*anthropic.Message, error) {
+	type contentBlock struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text,omitempty"`
+		ID    string          `json:"id,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+	}
+	content := []contentBlock{}
+	if text != "" {
+		content = append(content, contentBlock{Type: "text", Text: text})
+	}
+	indexes := []int{}
+	for index := range toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		call := toolCalls[index]
+		if call.ID == "" {
+			// some servers leave out the ids, but tool results need one to refer to
+			call.ID = fmt.Sprintf("call_%d", index)
+		}
+		input := json.RawMessage(call.Function.Arguments)
+		if strings.TrimSpace(call.Function.Arguments) == "" {
+			input = json.RawMessage("{}")
+		} else if !json.Valid(input) {
+			// keep the broken arguments as a string, which the agent refuses to
+			// call the tool with and instead shows the model in an error result
+			input, _ = json.Marshal(call.Function.Arguments)
+		}
+		content = append(content, contentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+	}
+
+	stopReason := anthropic.StopReasonEndTurn
+	switch {
+	case finishReason == "length":
+		stopReason = anthropic.StopReasonMaxTokens
+	case len(toolCalls) > 0:
+		// not every server finishes with "tool_calls" when it makes them
+		stopReason = anthropic.StopReasonToolUse
+	}
+
+	raw, err := json.Marshal(map[string]any{
+		"id":          id,
+		"type":        "message",
+		"role":        "assistant",
+		"model":       model,
+		"content":     content,
+		"stop_reason": stopReason,
+		"usage":       map[string]int64{"input_tokens": inputTokens, "output_tokens": outputTokens},
+	})
+	if err != nil {
+		return nil, err
+	}
+	message := anthropic.Message{}
+	err = json.Unmarshal(raw, &message)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
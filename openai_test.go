@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const testOpenAIStreamResponse = `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"Let me "}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"look."}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"read_file","arguments":""}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"main.go\"}"}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"name":"list_files","arguments":""}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: {"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19}}
+
+data: [DONE]
+
+`
+
+func TestOpenAIProvider(t *testing.T) {
+	var body map[string]any
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		authorization = r.Header.Get("Authorization")
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Errorf("failed to decode the request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, testOpenAIStreamResponse)
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(server.URL+"/v1/", "secret")
+	request := InferenceRequest{
+		Model:  "local",
+		System: "be brief",
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock("what is in main.go?")),
+			anthropic.NewAssistantMessage(anthropic.NewToolUseBlock("call_0", map[string]any{"path": "main.go"}, "read_file")),
+			anthropic.NewUserMessage(anthropic.NewToolResultBlock("call_0", "package main", false), anthropic.NewTextBlock("and more?")),
+		},
+		Tools:     []ToolDefinition{ReadFileDefinition},
+		MaxTokens: 100,
+	}
+	events := []StreamEvent{}
+	message, err := provider.Stream(context.Background(), request, func(event StreamEvent) {
+		events = append(events, event)
+	})
+	if err != nil {
+		t.Fatalf("failed to stream: %v", err)
+	}
+
+	// happy path: the request is translated into chat completions messages and tools
+	if authorization != "Bearer secret" {
+		t.Fatalf("expected a bearer token, got %q", authorization)
+	}
+	messages, _ := json.Marshal(body["messages"])
+	expected := `[{"content":"be brief","role":"system"},` +
+		`{"content":"what is in main.go?","role":"user"},` +
+		`{"content":"","role":"assistant","tool_calls":[{"function":{"arguments":"{\"path\":\"main.go\"}","name":"read_file"},"id":"call_0","type":"function"}]},` +
+		`{"content":"package main","role":"tool","tool_call_id":"call_0"},` +
+		`{"content":"and more?","role":"user"}]`
+	if string(messages) != expected {
+		t.Fatalf("unexpected messages:\n%s\nexpected:\n%s", messages, expected)
+	}
+	tools, _ := json.Marshal(body["tools"])
+	if !strings.Contains(string(tools), `"name":"read_file"`) || !strings.Contains(string(tools), `"type":"object"`) {
+		t.Fatalf("unexpected tools: %s", tools)
+	}
+	if body["stream"] != true || body["max_tokens"] != float64(100) || body["tool_choice"] != nil {
+		t.Fatalf("unexpected request: %v", body)
+	}
+
+	// happy path: the stream is assembled into a message with text, tool calls and usage
+	if len(message.Content) != 3 || message.Content[0].Text != "Let me look." {
+		t.Fatalf("unexpected content: %+v", message.Content)
+	}
+	if message.Content[1].Name != "read_file" || message.Content[1].ID != "call_a" || string(message.Content[1].Input) != `{"path":"main.go"}` {
+		t.Fatalf("unexpected tool call: %+v", message.Content[1])
+	}
+	if message.Content[2].Name != "list_files" || message.Content[2].ID != "call_1" || string(message.Content[2].Input) != "{}" {
+		t.Fatalf("expected a made up id and empty input, got %+v", message.Content[2])
+	}
+	if message.StopReason != anthropic.StopReasonToolUse || message.Usage.InputTokens != 12 || message.Usage.OutputTokens != 7 {
+		t.Fatalf("unexpected stop reason or usage: %s %+v", message.StopReason, message.Usage)
+	}
+	if message.ToParam().Content[1].OfToolUse == nil {
+		t.Fatalf("expected the message to convert back into a tool use param")
+	}
+	if len(events) != 4 || events[0].Type != StreamTextStart || events[1].Text != "Let me " || events[3].Type != StreamTextStop {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	// test a text-only request asks for no tool calls
+	request.NoToolUse = true
+	_, err = provider.Stream(context.Background(), request, nil)
+	if err != nil || body["tool_choice"] != "none" {
+		t.Fatalf("expected tool_choice none, got %v and %v", body["tool_choice"], err)
+	}
+}
+
+func TestOpenAIProviderErrors(t *testing.T) {
+	responses := []func(w http.ResponseWriter){
+		respondStatus(503, "Retry-After", "3"),
+		respondStream("data: {\"error\":{\"code\":500,\"message\":\"model crashed\",\"type\":\"server_error\"}}\n\n"),
+		respondStream("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"cut\"},\"finish_reason\":\"length\"}]}\n\ndata: [DONE]\n\n"),
+		respondStream("data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_a\",\"function\":{\"name\":\"read_file\",\"arguments\":\"{\\\"path\\\": main.go\"}}]}}]}\n\ndata: [DONE]\n\n"),
+		respondStream("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"cut\"}}]}\n\n"),
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses[requests](w)
+		requests++
+	}))
+	defer server.Close()
+	provider := NewOpenAIProvider(server.URL, "")
+	request := InferenceRequest{Model: "local", Messages: []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("hi"))}}
+
+	// happy path: an error status is retried after the time the server asks for
+	_, err := provider.Stream(context.Background(), request, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Fatalf("expected an API error, got %v", err)
+	}
+	reason, retryAfter, ok := retryReason(err)
+	if !ok || retryAfter != 3*time.Second || !strings.Contains(reason, "503") {
+		t.Fatalf("expected a retry after 3s, got %q %s %t", reason, retryAfter, ok)
+	}
+
+	// test an error in the stream fails the request
+	_, err = provider.Stream(context.Background(), request, nil)
+	if err == nil || !strings.Contains(err.Error(), "model crashed") {
+		t.Fatalf("expected the stream's error, got %v", err)
+	}
+
+	// test a response cut short reports max tokens
+	message, err := provider.Stream(context.Background(), request, nil)
+	if err != nil || message.StopReason != anthropic.StopReasonMaxTokens || message.Content[0].Text != "cut" {
+		t.Fatalf("expected a max tokens stop, got %+v and %v", message, err)
+	}
+
+	// test broken tool arguments are kept as a string, which the agent refuses as tool input
+	message, err = provider.Stream(context.Background(), request, nil)
+	if err != nil || string(message.Content[0].Input) != `"{\"path\": main.go"` || isJSONObject(message.Content[0].Input) {
+		t.Fatalf("expected the arguments as a string, got %+v and %v", message, err)
+	}
+	sent := openAIMessages(message.ToParam())
+	if len(sent) != 1 || sent[0].ToolCalls[0].Function.Arguments != `{"path": main.go` {
+		t.Fatalf("expected the broken arguments to be sent back as they were, got %+v", sent)
+	}
+
+	// test a stream that ends without finishing the response is retried
+	_, err = provider.Stream(context.Background(), request, nil)
+	if _, _, ok := retryReason(err); !ok {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+
+	// test the configuration picks the provider
+	config := DefaultConfig()
+	config.Provider = providerOpenAI
+	chosen, err := NewProvider(config)
+	if openAI, ok := chosen.(*OpenAIProvider); err != nil || !ok || openAI.baseURL != defaultOpenAIBaseURL {
+		t.Fatalf("expected an OpenAI provider on the default URL, got %#v and %v", chosen, err)
+	}
+}
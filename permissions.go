@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	permissionAllow = "allow"
+	permissionDeny  = "deny"
+)
+
+// PermissionRule allows or denies calls to a mutating tool. Pattern is a glob
+// matched against the call's path; a pattern without a slash matches the base
+// name at any depth. For run_command it is a command, matched as described at
+// matchCommand. An empty pattern matches every call. Exact rules, which the
+// permission prompt saves, only match the very call they were saved for.
+type PermissionRule struct {
+	Tool    string `json:"tool"`
+	Pattern string `json:"pattern,omitempty"`
+	Action  string `json:"action"`
+	Exact   bool   `json:"exact,omitempty"`
+}
+
+func (r PermissionRule) matches(tool, target string) bool {
+	if r.Tool != tool && r.Tool != "*" {
+		return false
+	}
+	if r.Pattern == "" || r.Pattern == target {
+		return true
+	}
+	if r.Exact {
+		return false
+	}
+	if tool == RunCommandDefinition.Name {
+		return matchCommand(r.Pattern, target)
+	}
+	return matchPathGlob(r.Pattern, target)
+}
+
+// shellOperators are the characters that let a command chain, substitute or
+// redirect into another one.
+const shellOperators = ";&|<>`$()\n"
+
+// matchCommand reports whether a run_command rule's pattern covers the
+// command. A pattern covers only the exact command, unless it ends in " *":
+// then it also covers the command with more arguments, so "go test *" covers
+// "go test ./...". Such a pattern never covers a command with shell
+// operators, so that it can't let another command through.
+func matchCommand(pattern, command string) bool {
+	if pattern == command {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, " *")
+	if !ok || strings.ContainsAny(command, shellOperators) {
+		return false
+	}
+	patternArgs := strings.Fields(prefix)
+	commandArgs := strings.Fields(command)
+	if len(patternArgs) == 0 || len(patternArgs) > len(commandArgs) {
+		return false
+	}
+	return slices.Equal(patternArgs, commandArgs[:len(patternArgs)])
+}
+
+// Permissions holds the rules that decide whether a mutating tool call needs
+// the user's approval. Project rules are saved to a file; session rules only
+// last until the agent exits.
+type Permissions struct {
+	path  string
+	trust *TrustStore
+	rules []PermissionRule
+	// untrusted are the allow rules of a file the user hasn't approved, which
+	// aren't used until they are
+	untrusted    []PermissionRule
+	sessionRules []PermissionRule
+}
+
+// LoadPermissions reads the project rules from path. A missing file means no
+// rules yet. The file may have come with the repository, so unless trust says
+// the user approved it, only its deny rules are used; see TrustRules. A nil
+// trust store trusts the file.
+func LoadPermissions(path string, trust *TrustStore) (*Permissions, error) {
+	permissions := &Permissions{path: path, trust: trust, rules: []PermissionRule{}}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return permissions, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, &permissions.rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse permissions file %s: %w", path, err)
+	}
+	for _, rule := range permissions.rules {
+		if rule.Action != permissionAllow && rule.Action != permissionDeny {
+			return nil, fmt.Errorf("invalid action %q in permissions file %s", rule.Action, path)
+		}
+	}
+	if trust != nil && !trust.Trusted(path, content) {
+		rules := permissions.rules
+		permissions.rules = []PermissionRule{}
+		for _, rule := range rules {
+			if rule.Action == permissionAllow {
+				permissions.untrusted = append(permissions.untrusted, rule)
+			} else {
+				permissions.rules = append(permissions.rules, rule)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// UntrustedRules returns the allow rules that wait for the user's approval.
+func (p *Permissions) UntrustedRules() []PermissionRule {
+	return p.untrusted
+}
+
+// TrustRules starts using the allow rules that waited for the user's approval
+// and remembers the approval for the file as it is now.
+func (p *Permissions) TrustRules() error {
+	p.rules = append(p.rules, p.untrusted...)
+	p.untrusted = nil
+	content, err := os.ReadFile(p.path)
+	if err != nil || p.trust == nil {
+		return err
+	}
+	return p.trust.Trust(p.path, content)
+}
+
+// Check returns the action of the rules matching the call. Deny rules win over
+// allow rules, and found is false when no rule matches.
+func (p *Permissions) Check(tool, target string) (action string, found bool) {
+	rules := append(append([]PermissionRule{}, p.rules...), p.sessionRules...)
+	for _, rule := range rules {
+		if !rule.matches(tool, target) {
+			continue
+		}
+		if rule.Action == permissionDeny {
+			return permissionDeny, true
+		}
+		action, found = permissionAllow, true
+	}
+	return action, found
+}
+
+// CheckAll checks a call that touches several targets. It is denied if any
+// target is denied and allowed if every target is allowed; otherwise, and
+// when there are no targets, found is false.
+func (p *Permissions) CheckAll(tool string, targets []string) (action string, found bool) {
+	allowed := len(targets) > 0
+	for _, target := range targets {
+		action, found := p.Check(tool, target)
+		if found && action == permissionDeny {
+			return permissionDeny, true
+		}
+		allowed = allowed && found
+	}
+	if allowed {
+		return permissionAllow, true
+	}
+	return "", false
+}
+
+// AddSessionRule adds a rule that lasts until the agent exits.
+func (p *Permissions) AddSessionRule(rule PermissionRule) {
+	p.sessionRules = append(p.sessionRules, rule)
+}
+
+// AddRule adds a project rule and saves it. The saved file stays approved
+// unless it still has rules waiting for approval.
+func (p *Permissions) AddRule(rule PermissionRule) error {
+	p.rules = append(p.rules, rule)
+	if p.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(append(append([]PermissionRule{}, p.rules...), p.untrusted...), "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	err = os.MkdirAll(filepath.Dir(p.path), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(p.path, content, 0644)
+	if err != nil || p.trust == nil || len(p.untrusted) > 0 {
+		return err
+	}
+	return p.trust.Trust(p.path, content)
+}
+
+// confirmPermissions asks the user whether to use the allow rules of a
+// permissions file they haven't approved, which may have come with the
+// repository. Unless they say yes, the calls the rules cover keep asking.
+func confirmPermissions(p *Permissions, getUserMessage func() (string, bool)) error {
+	rules := p.UntrustedRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	fmt.Printf("%s allows these calls without asking:\n", p.path)
+	for _, rule := range rules {
+		pattern := rule.Pattern
+		if pattern == "" {
+			pattern = "(any)"
+		}
+		fmt.Printf("  %s %s\n", rule.Tool, pattern)
+	}
+	fmt.Print("\u001b[95mUse these rules?\u001b[0m [y/n]: ")
+	answer, ok := getUserMessage()
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if !ok || (answer != "y" && answer != "yes") {
+		fmt.Println("Not using them; these calls will ask for approval.")
+		return nil
+	}
+	return p.TrustRules()
+}
+
+// permissionTargets picks what permission rules are matched against from a
+// tool call's input: its path relative to the workspace root, or its command
+// for run_command. A patch has a target for every file it touches, and none if
+// it can't be parsed.
+func permissionTargets(name string, input json.RawMessage) []string {
+	fields := struct {
+		Path    string `json:"path"`
+		Command string `json:"command"`
+		Patch   string `json:"patch"`
+	}{}
+	json.Unmarshal(input, &fields)
+	if name == ApplyPatchDefinition.Name {
+		files, err := parsePatch(fields.Patch)
+		if err != nil {
+			return nil
+		}
+		targets := []string{}
+		for _, file := range files {
+			for _, path := range []string{file.oldPath, file.newPath} {
+				if path != "" && !slices.Contains(targets, permissionPath(path)) {
+					targets = append(targets, permissionPath(path))
+				}
+			}
+		}
+		return targets
+	}
+	if fields.Path != "" {
+		return []string{permissionPath(fields.Path)}
+	}
+	return []string{fields.Command}
+}
+
+// permissionPath turns a path given to a tool into the form path rules match:
+// relative to the workspace root, with forward slashes.
+func permissionPath(p string) string {
+	path, err := workspace.Resolve(p)
+	if err != nil {
+		return filepath.ToSlash(filepath.Clean(p))
+	}
+	return filepath.ToSlash(workspace.Rel(path))
+}
+
+// checkPermission decides whether a mutating tool call may run, asking the user
+// when no rule covers it. If the call is denied it returns the reason to give the model.
+func (a *Agent) checkPermission(ctx context.Context, name string, input json.RawMessage) (bool, string) {
+	targets := permissionTargets(name, input)
+	action, found := a.permissions.CheckAll(name, targets)
+	if found {
+		if action == permissionDeny {
+			return false, "the call is denied by a project permission rule"
+		}
+		return true, ""
+	}
+
+	a.previewToolCall(ctx, name, input)
+	for {
+		on := ""
+		if len(targets) > 0 && targets[0] != "" {
+			on = " on " + strings.Join(targets, ", ")
+		}
+		fmt.Printf("\u001b[95mAllow %s%s?\u001b[0m [y] once, [s] for this session, [a] always, [n] deny, [d] always deny: ", name, on)
+		answer, ok := a.getUserMessage()
+		if !ok {
+			return false, "the user did not answer the permission prompt"
+		}
+
+		choice := strings.ToLower(strings.TrimSpace(answer))
+		switch choice {
+		case "y", "yes":
+			return true, ""
+		case "s":
+			for _, target := range targets {
+				a.permissions.AddSessionRule(PermissionRule{Tool: name, Pattern: target, Action: permissionAllow, Exact: true})
+			}
+			return true, ""
+		case "a":
+			for _, target := range targets {
+				err := a.permissions.AddRule(PermissionRule{Tool: name, Pattern: target, Action: permissionAllow, Exact: true})
+				if err != nil {
+					fmt.Printf("\u001b[91mwarning\u001b[0m: failed to save permission rule: %s\n", err.Error())
+				}
+			}
+			return true, ""
+		case "n", "no", "d":
+			if choice == "d" {
+				for _, target := range targets {
+					err := a.permissions.AddRule(PermissionRule{Tool: name, Pattern: target, Action: permissionDeny, Exact: true})
+					if err != nil {
+						fmt.Printf("\u001b[91mwarning\u001b[0m: failed to save permission rule: %s\n", err.Error())
+					}
+				}
+			}
+			fmt.Print("\u001b[95mReason (optional)\u001b[0m: ")
+			reason, _ := a.getUserMessage()
+			reason = strings.TrimSpace(reason)
+			if reason == "" {
+				return false, "the user denied this tool call"
+			}
+			return false, "the user denied this tool call: " + reason
+		}
+	}
+}
+
+// previewToolCall shows the user what a mutating tool call is about to do.
+func (a *Agent) previewToolCall(ctx context.Context, name string, input json.RawMessage) {
+	switch name {
+	case EditFileDefinition.Name:
+		editFileInput := EditFileInput{}
+		if json.Unmarshal(input, &editFileInput) != nil {
+			break
+		}
+		path, err := workspace.Resolve(editFileInput.Path)
+		if err != nil {
+			break
+		}
+		content, err := os.ReadFile(path)
+		oldContent := string(content)
+		if err != nil || strings.Count(oldContent, editFileInput.OldStr) != 1 {
+			// the edit is a new file or will fail, so just show the text it adds
+			oldContent = ""
+		}
+		newContent := strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, 1)
+		if oldContent == "" {
+			newContent = editFileInput.NewStr
+		}
+		fmt.Print(colorDiff(unifiedDiff(workspace.Rel(path), oldContent, newContent, diffContextLines)))
+		return
+	case MultiEditDefinition.Name:
+		multiEditInput := MultiEditInput{}
+		if json.Unmarshal(input, &multiEditInput) != nil {
+			break
+		}
+		path, err := workspace.Resolve(multiEditInput.Path)
+		if err != nil {
+			break
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			break
+		}
+		newContent, err := applyEdits(string(content), multiEditInput.Edits)
+		if err != nil {
+			// show the user why the call is going to fail
+			fmt.Println(err.Error())
+			break
+		}
+		fmt.Print(colorDiff(unifiedDiff(workspace.Rel(path), string(content), newContent, diffContextLines)))
+		return
+	case ApplyPatchDefinition.Name:
+		applyPatchInput := ApplyPatchInput{}
+		if json.Unmarshal(input, &applyPatchInput) != nil {
+			break
+		}
+		fmt.Print(colorDiff(strings.TrimSuffix(applyPatchInput.Patch, "\n") + "\n"))
+		return
+	case DeleteLinesDefinition.Name:
+		deleteLinesInput := DeleteLinesInput{}
+		if json.Unmarshal(input, &deleteLinesInput) != nil {
+			break
+		}
+		path, err := workspace.Resolve(deleteLinesInput.Path)
+		if err != nil {
+			break
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			break
+		}
+		lines := strings.Split(string(content), "\n")
+		if deleteLinesInput.StartLine < 1 || deleteLinesInput.EndLine < deleteLinesInput.StartLine || deleteLinesInput.EndLine > len(lines) {
+			break
+		}
+		newContent := strings.Join(append(lines[:deleteLinesInput.StartLine-1:deleteLinesInput.StartLine-1], lines[deleteLinesInput.EndLine:]...), "\n")
+		fmt.Print(colorDiff(unifiedDiff(workspace.Rel(path), string(content), newContent, diffContextLines)))
+		return
+	case GitCommitDefinition.Name:
+		gitCommitInput := GitCommitInput{}
+		if json.Unmarshal(input, &gitCommitInput) != nil {
+			break
+		}
+		fmt.Printf("commit to branch %s:\n%s\n", gitBranch, strings.TrimRight(gitCommitInput.Message, "\n"))
+		if len(gitCommitInput.Paths) > 0 {
+			fmt.Printf("paths: %s\n", strings.Join(gitCommitInput.Paths, ", "))
+		}
+		status, err := runGit(ctx, "status", "--short")
+		if err == nil && status != "" {
+			fmt.Print(status)
+		}
+		return
+	case RunCommandDefinition.Name:
+		runCommandInput := RunCommandInput{}
+		if json.Unmarshal(input, &runCommandInput) != nil {
+			break
+		}
+		fmt.Printf("$ %s\n", runCommandInput.Command)
+		return
+	}
+	fmt.Println(toJSON(json.RawMessage(input)))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+const (
+	providerAnthropic = "anthropic"
+	providerOpenAI    = "openai"
+)
+
+// Provider is a model backend the agent talks to. The agent keeps the
+// conversation in the Anthropic message format, so providers for other APIs
+// translate the request and build their response in that format.
+type Provider interface {
+	// Stream sends the request and returns the complete response, including
+	// its token usage. While the response streams in, its text is passed to
+	// onEvent unless onEvent is nil.
+	Stream(ctx context.Context, request InferenceRequest, onEvent func(StreamEvent)) (*anthropic.Message, error)
+}
+
+// InferenceRequest is what the agent asks a provider for.
+type InferenceRequest struct {
+	Model    string
+	System   string
+	Messages []anthropic.MessageParam
+	Tools    []ToolDefinition
+	// NoToolUse asks for a plain text response. The tools stay defined
+	// because a history with tool calls needs them.
+	NoToolUse     bool
+	MaxTokens     int64
+	Temperature   *float64
+	StopSequences []string
+}
+
+type StreamEventType int
+
+const (
+	// StreamTextStart starts a text block, StreamTextDelta carries its text
+	// as it arrives and StreamTextStop ends it.
+	StreamTextStart StreamEventType = iota
+	StreamTextDelta
+	StreamTextStop
+)
+
+type StreamEvent struct {
+	Type StreamEventType
+	Text string
+}
+
+// APIError is an HTTP API that answered a request with an error status.
+type APIError struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// NewProvider creates the provider the configuration asks for.
+func NewProvider(config Config) (Provider, error) {
+	switch config.Provider {
+	case providerAnthropic:
+		options := []option.RequestOption{}
+		if config.BaseURL != "" {
+			options = append(options, option.WithBaseURL(config.BaseURL))
+		}
+		return NewAnthropicProvider(options...), nil
+	case providerOpenAI:
+		baseURL := config.BaseURL
+		if baseURL == "" {
+			baseURL = defaultOpenAIBaseURL
+		}
+		return NewOpenAIProvider(baseURL, os.Getenv("OPENAI_API_KEY")), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", config.Provider)
+}
+
+// AnthropicProvider talks to the Anthropic Messages API.
+type AnthropicProvider struct {
+	client anthropic.Client
+}
+
+// NewAnthropicProvider creates a provider with a client configured by the
+// options and the ANTHROPIC_* environment variables.
+func NewAnthropicProvider(options ...option.RequestOption) *AnthropicProvider {
+	// the agent retries requests itself, so it can show the user what is going on
+	options = append([]option.RequestOption{option.WithMaxRetries(0)}, options...)
+	return &AnthropicProvider{client: anthropic.NewClient(options...)}
+}
+
+func (p *AnthropicProvider) Stream(ctx context.Context, request InferenceRequest, onEvent func(StreamEvent)) (*anthropic.Message, error) {
+	if onEvent == nil {
+		onEvent = func(StreamEvent) {}
+	}
+	stream := p.client.Messages.NewStreaming(ctx, anthropicParams(request))
+	defer stream.Close()
+
+	// accumulate the streamed events into a full message (this also assembles
+	// tool_use inputs from their partial JSON deltas) while passing text on as it arrives
+	message := anthropic.Message{}
+	for stream.Next() {
+		event := stream.Current()
+		err := message.Accumulate(event)
+		if err != nil {
+			return nil, err
+		}
+
+		switch event := event.AsAny().(type) {
+		case anthropic.ContentBlockStartEvent:
+			if event.ContentBlock.Type == "text" {
+				onEvent(StreamEvent{Type: StreamTextStart})
+			}
+		case anthropic.ContentBlockDeltaEvent:
+			if delta, ok := event.Delta.AsAny().(anthropic.TextDelta); ok {
+				onEvent(StreamEvent{Type: StreamTextDelta, Text: delta.Text})
+			}
+		case anthropic.ContentBlockStopEvent:
+			if message.Content[len(message.Content)-1].Type == "text" {
+				onEvent(StreamEvent{Type: StreamTextStop})
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// anthropicParams turns the request into the parameters of the Messages API.
+func anthropicParams(request InferenceRequest) anthropic.MessageNewParams {
+	anthropicTools := []anthropic.ToolUnionParam{}
+	for _, tool := range request.Tools {
+		anthropicTools = append(anthropicTools, anthropic.ToolUnionParam{
+			OfTool: &anthropic.ToolParam{
+				Name:        tool.Name,
+				Description: anthropic.String(tool.Description),
+				InputSchema: tool.InputSchema,
+			},
+		})
+	}
+
+	params := anthropic.MessageNewParams{
+		Model:         anthropic.Model(request.Model),
+		MaxTokens:     request.MaxTokens,
+		Messages:      request.Messages,
+		Tools:         anthropicTools,
+		StopSequences: request.StopSequences,
+	}
+	if request.System != "" {
+		params.System = []anthropic.TextBlockParam{{Text: request.System}}
+	}
+	if request.Temperature != nil {
+		params.Temperature = anthropic.Float(*request.Temperature)
+	}
+	if request.NoToolUse {
+		params.ToolChoice = anthropic.ToolChoiceUnionParam{OfNone: &anthropic.ToolChoiceNoneParam{}}
+	}
+	return params
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const testToolUseStreamResponse = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"test","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":0}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"look."}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"read_file","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\": "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"main.go\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestAnthropicProviderStream(t *testing.T) {
+	agent, _ := newRetryTestAgent(t, respondStream(testToolUseStreamResponse))
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("what is in main.go?"))}
+
+	// happy path: text is printed as it arrives and the tool call is put together from its deltas
+	var message *anthropic.Message
+	var err error
+	output := captureStdout(t, func() {
+		message, err = agent.runInference(context.Background(), conversation)
+	})
+	if err != nil {
+		t.Fatalf("failed to run inference: %v", err)
+	}
+	if !strings.Contains(output, "Let me look.\n") {
+		t.Fatalf("expected the streamed text to be printed, got %q", output)
+	}
+	if len(message.Content) != 2 || message.Content[0].Text != "Let me look." {
+		t.Fatalf("unexpected content: %+v", message.Content)
+	}
+	toolUse := message.Content[1]
+	if toolUse.Type != "tool_use" || toolUse.Name != "read_file" || strings.ReplaceAll(string(toolUse.Input), " ", "") != `{"path":"main.go"}` {
+		t.Fatalf("unexpected tool call: %+v", toolUse)
+	}
+	if message.StopReason != anthropic.StopReasonToolUse || message.Usage.InputTokens != 10 || message.Usage.OutputTokens != 12 {
+		t.Fatalf("unexpected stop reason or usage: %s %+v", message.StopReason, message.Usage)
+	}
+
+	// test the streamed message turns into the same history entry a complete one would
+	param := message.ToParam()
+	if param.Role != anthropic.MessageParamRoleAssistant || param.Content[0].OfText == nil || param.Content[1].OfToolUse == nil || param.Content[1].OfToolUse.ID != "toolu_1" {
+		t.Fatalf("unexpected history entry: %+v", param)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var RenameSymbolDefinition = ToolDefinition{
+	Name: "rename_symbol",
+	Description: `Rename a Go identifier and every reference to it across the module.
+
+Give the file and line where the identifier is declared or used, its current name and the new name. The module is type-checked to find the references, so other things with the same name are left alone. All files are changed together, or none are.
+
+The rename is refused if the module doesn't type-check, if the new name is already used where it would clash or change what another identifier refers to, or if an exported name would become unexported while other packages use it. Renaming a method doesn't rename the methods of interfaces it satisfies; if that leaves the module not type-checking, the rename is undone.
+`,
+	InputSchema: RenameSymbolInputSchema,
+	Function:    RenameSymbol,
+	Mutating:    true,
+}
+
+type RenameSymbolInput struct {
+	GoIdentifierInput
+	NewName string `json:"new_name" jsonschema_description:"The new name for the identifier"`
+}
+
+var RenameSymbolInputSchema = GenerateSchema[RenameSymbolInput]()
+
+func RenameSymbol(ctx context.Context, input json.RawMessage) (string, error) {
+	renameInput := RenameSymbolInput{}
+	err := json.Unmarshal(input, &renameInput)
+	if err != nil {
+		return "", err
+	}
+
+	if !token.IsIdentifier(renameInput.NewName) || renameInput.NewName == "_" {
+		return "", fmt.Errorf("%q is not a valid Go identifier", renameInput.NewName)
+	}
+	if renameInput.NewName == renameInput.Symbol {
+		return "", fmt.Errorf("the new name is the same as the old one")
+	}
+
+	pkgs, obj, err := lookupGoObject(ctx, renameInput.GoIdentifierInput, true)
+	if err != nil {
+		return "", err
+	}
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return "", fmt.Errorf("package %s has errors, fix them before renaming: %s", pkg.PkgPath, pkg.Errors[0].Error())
+		}
+	}
+
+	declaration := pkgs[0].Fset.Position(obj.Pos())
+	if !obj.Pos().IsValid() || !isWithin(workspace.Root(), declaration.Filename) {
+		return "", fmt.Errorf("%s is not declared in the workspace", obj.Name())
+	}
+	if _, ok := obj.(*types.PkgName); ok {
+		return "", fmt.Errorf("renaming imports is not supported")
+	}
+
+	err = checkRenameConflicts(pkgs, declaration, renameInput.NewName)
+	if err != nil {
+		return "", fmt.Errorf("cannot rename %s to %s: %w", obj.Name(), renameInput.NewName, err)
+	}
+
+	references := findGoReferences(pkgs, declaration)
+	files := map[string][]token.Position{}
+	for _, reference := range references {
+		files[reference.Filename] = append(files[reference.Filename], reference)
+	}
+
+	// build every new file before writing any, so a failure changes nothing
+	contents := map[string][]byte{}
+	for path, positions := range files {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		// replace from the end so the earlier offsets stay valid
+		for i := len(positions) - 1; i >= 0; i-- {
+			offset := positions[i].Offset
+			if offset+len(obj.Name()) > len(content) || string(content[offset:offset+len(obj.Name())]) != obj.Name() {
+				return "", fmt.Errorf("%s changed since it was type-checked", displayPath(path))
+			}
+			content = append(content[:offset:offset], append([]byte(renameInput.NewName), content[offset+len(obj.Name()):]...)...)
+		}
+		contents[path] = content
+	}
+
+	paths := make([]string, 0, len(contents))
+	for path := range contents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// keep the files as they were, so a failed write or a rename that breaks
+	// the module can be undone
+	snapshots := map[string]fileSnapshot{}
+	for _, path := range paths {
+		snapshots[path], err = readSnapshot(path)
+		if err != nil {
+			return "", err
+		}
+	}
+	// the loaded packages are out of date from here on, whatever happens
+	defer forgetGoPackages()
+	for i, path := range paths {
+		err = workspace.WriteFile(path, contents[path])
+		if err != nil {
+			return "", undoRename(fmt.Errorf("failed to write %s: %w", displayPath(path), err), paths[:i+1], snapshots)
+		}
+	}
+
+	// a renamed method can leave its type no longer satisfying an interface,
+	// which only type-checking the result finds
+	pkgs, err = loadGoPackages(ctx, "./...")
+	if err == nil {
+		for _, pkg := range pkgs {
+			if len(pkg.Errors) > 0 {
+				err = fmt.Errorf("the rename breaks package %s: %s", pkg.PkgPath, pkg.Errors[0].Error())
+				break
+			}
+		}
+	}
+	if err != nil {
+		return "", undoRename(err, paths, snapshots)
+	}
+
+	output := strings.Builder{}
+	output.WriteString(fmt.Sprintf("Renamed %s to %s: %d references in %d files\n", obj.Name(), renameInput.NewName, len(references), len(paths)))
+	for _, path := range paths {
+		output.WriteString(displayPath(path) + "\n")
+	}
+	return output.String(), nil
+}
+
+// undoRename puts the files back the way the snapshots found them and returns
+// err saying so.
+func undoRename(err error, paths []string, snapshots map[string]fileSnapshot) error {
+	for _, path := range paths {
+		restoreErr := snapshots[path].restore(path)
+		if restoreErr != nil {
+			return fmt.Errorf("%w; failed to restore %s: %v", err, displayPath(path), restoreErr)
+		}
+	}
+	return fmt.Errorf("%w; the rename was undone", err)
+}
+
+// checkRenameConflicts returns an error if renaming the object declared at
+// declaration to newName would not compile or would change what some
+// identifier refers to. It checks every package, and test variant, that
+// declares the object.
+func checkRenameConflicts(pkgs []*packages.Package, declaration token.Position, newName string) error {
+	for _, pkg := range pkgs {
+		obj := definedObjectAt(pkg, declaration)
+		if obj == nil {
+			if !token.IsExported(newName) && referencesObject(pkg, declaration) {
+				return fmt.Errorf("it is used by package %s and would become unexported", pkg.PkgPath)
+			}
+			continue
+		}
+
+		if obj.Parent() == nil {
+			err := checkMemberConflicts(pkg, obj, newName)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the new name is already declared in the same scope
+		scope := obj.Parent()
+		if existing := scope.Lookup(newName); existing != nil {
+			return fmt.Errorf("%s is already declared at %s", newName, displayPosition(pkg.Fset, existing.Pos()))
+		}
+		if scope == pkg.Types.Scope() {
+			for _, file := range pkg.Syntax {
+				if existing := pkg.TypesInfo.Scopes[file].Lookup(newName); existing != nil {
+					return fmt.Errorf("%s is already declared at %s", newName, displayPosition(pkg.Fset, existing.Pos()))
+				}
+			}
+		}
+
+		for ident, used := range pkg.TypesInfo.Uses {
+			switch {
+			case used == obj:
+				// a reference would find a closer declaration of the new name
+				inner, found := pkg.Types.Scope().Innermost(ident.Pos()).LookupParent(newName, ident.Pos())
+				if found != nil && inner != scope && scopeWithin(inner, scope) {
+					return fmt.Errorf("%s at %s would refer to the %s declared at %s", newName, displayPosition(pkg.Fset, ident.Pos()), newName, displayPosition(pkg.Fset, found.Pos()))
+				}
+			case used.Name() == newName:
+				// a use of another object with the new name would find the renamed one instead
+				inScope := scope == pkg.Types.Scope() || (scope.Contains(ident.Pos()) && ident.Pos() > obj.Pos())
+				if inScope && used.Parent() != nil && used.Parent() != scope && scopeWithin(scope, used.Parent()) {
+					return fmt.Errorf("%s at %s would refer to the renamed %s", newName, displayPosition(pkg.Fset, ident.Pos()), obj.Name())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkMemberConflicts checks renaming a method or struct field, which clash
+// with the other fields and methods of their type.
+func checkMemberConflicts(pkg *packages.Package, obj types.Object, newName string) error {
+	var owner types.Type
+	switch obj := obj.(type) {
+	case *types.Func:
+		if recv := obj.Type().(*types.Signature).Recv(); recv != nil {
+			owner = recv.Type()
+		}
+	case *types.Var:
+		if obj.IsField() {
+			owner = fieldOwner(pkg, obj)
+		}
+	}
+	if owner == nil {
+		return nil
+	}
+	existing, _, _ := types.LookupFieldOrMethod(owner, true, pkg.Types, newName)
+	if existing != nil {
+		return fmt.Errorf("%s already has %s at %s", types.TypeString(owner, types.RelativeTo(pkg.Types)), newName, displayPosition(pkg.Fset, existing.Pos()))
+	}
+	return nil
+}
+
+// fieldOwner returns the named type in the package whose struct has the field,
+// or nil if the field belongs to an unnamed struct.
+func fieldOwner(pkg *packages.Package, field *types.Var) types.Type {
+	for _, obj := range pkg.TypesInfo.Defs {
+		typeName, ok := obj.(*types.TypeName)
+		if !ok {
+			continue
+		}
+		structType, ok := typeName.Type().Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := 0; i < structType.NumFields(); i++ {
+			if structType.Field(i) == field {
+				return typeName.Type()
+			}
+		}
+	}
+	return nil
+}
+
+// definedObjectAt returns the object the package declares at the position,
+// or nil if it doesn't declare one there.
+func definedObjectAt(pkg *packages.Package, position token.Position) types.Object {
+	if pkg.TypesInfo == nil {
+		return nil
+	}
+	for ident, obj := range pkg.TypesInfo.Defs {
+		if obj != nil && samePosition(pkg.Fset.Position(ident.Pos()), position) {
+			return obj
+		}
+	}
+	return nil
+}
+
+func referencesObject(pkg *packages.Package, declaration token.Position) bool {
+	if pkg.TypesInfo == nil {
+		return false
+	}
+	for _, obj := range pkg.TypesInfo.Uses {
+		if samePosition(pkg.Fset.Position(obj.Pos()), declaration) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeWithin reports whether inner is outer or nested inside it.
+func scopeWithin(inner, outer *types.Scope) bool {
+	for scope := inner; scope != nil; scope = scope.Parent() {
+		if scope == outer {
+			return true
+		}
+	}
+	return false
+}
+
+func displayPosition(fset *token.FileSet, pos token.Pos) string {
+	if !pos.IsValid() {
+		return "the universe scope"
+	}
+	position := fset.Position(pos)
+	return fmt.Sprintf("%s:%d:%d", displayPath(position.Filename), position.Line, position.Column)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameSymbol(t *testing.T) {
+	root := newGoTestModule(t)
+
+	// test conflicts are refused
+	conflicts := []string{
+		// another declaration in the package
+		`{"path": "greet/greet.go", "line": 10, "symbol": "Hello", "new_name": "Version"}`,
+		// another method of the type
+		`{"path": "greet/greet.go", "line": 5, "symbol": "Name", "new_name": "Greet"}`,
+		// used by another package
+		`{"path": "greet/greet.go", "line": 10, "symbol": "Hello", "new_name": "hello"}`,
+		// not an identifier
+		`{"path": "greet/greet.go", "line": 10, "symbol": "Hello", "new_name": "say-hello"}`,
+	}
+	for _, input := range conflicts {
+		_, err := RenameSymbol(context.Background(), json.RawMessage(input))
+		if err == nil {
+			t.Fatalf("expected error for %s, got nil", input)
+		}
+	}
+
+	// happy path: renamed across packages and tests, but not the unrelated local variable
+	result, err := RenameSymbol(context.Background(), json.RawMessage(`{"path": "main.go", "line": 10, "symbol": "Hello", "new_name": "Hi"}`))
+	if err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	expected := "Renamed Hello to Hi: 4 references in 3 files\ngreet/greet.go\ngreet/greet_test.go\nmain.go\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+	content, _ := os.ReadFile(filepath.Join(root, "main.go"))
+	if !strings.Contains(string(content), "greet.Hi(\"world\")") || !strings.Contains(string(content), "Hello := 1") {
+		t.Fatalf("unexpected main.go: %s", content)
+	}
+	content, _ = os.ReadFile(filepath.Join(root, "greet", "greet.go"))
+	if !strings.Contains(string(content), "func Hi(name string)") || !strings.Contains(string(content), "return Hi(g.Name)") {
+		t.Fatalf("unexpected greet.go: %s", content)
+	}
+
+	// test a method rename that breaks an interface is undone
+	err = os.WriteFile(filepath.Join(root, "greet", "iface.go"), []byte("package greet\n\ntype greeter interface{ Greet() string }\n\nvar _ greeter = (*Greeter)(nil)\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	before, _ := os.ReadFile(filepath.Join(root, "greet", "greet.go"))
+	_, err = RenameSymbol(context.Background(), json.RawMessage(`{"path": "greet/greet.go", "line": 15, "symbol": "Greet", "new_name": "Welcome"}`))
+	if err == nil || !strings.Contains(err.Error(), "the rename was undone") {
+		t.Fatalf("expected the rename to be undone, got %v", err)
+	}
+	after, _ := os.ReadFile(filepath.Join(root, "greet", "greet.go"))
+	if string(after) != string(before) {
+		t.Fatalf("expected greet.go to be restored, got %s", after)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// RetryPolicy decides how API requests that fail for a temporary reason, such
+// as rate limiting, overloading or a dropped connection, are retried.
+type RetryPolicy struct {
+	MaxRetries int
+	// BaseDelay is the delay before the first retry. It doubles for every
+	// retry after that, up to MaxDelay, and is jittered so that clients don't
+	// retry in lockstep.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func DefaultRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{MaxRetries: maxRetries, BaseDelay: time.Second, MaxDelay: time.Minute}
+}
+
+// Do calls request until it succeeds, fails with an error that isn't worth
+// retrying, or the retries run out, and returns its last error. Between
+// attempts it counts down the delay, which the server's retry-after header
+// sets when it sends one.
+func (p RetryPolicy) Do(ctx context.Context, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := request()
+		if err == nil || ctx.Err() != nil || attempt >= p.MaxRetries {
+			return err
+		}
+		reason, retryAfter, ok := retryReason(err)
+		if !ok {
+			return err
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = p.backoff(attempt)
+		}
+		err = countdown(ctx, delay, fmt.Sprintf("\u001b[91mwarning\u001b[0m: %s, retrying (%d of %d)", reason, attempt+1, p.MaxRetries))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt+1: between half and
+// all of BaseDelay doubled attempt times, capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 32 {
+		delay = min(p.BaseDelay*time.Duration(1<<attempt), p.MaxDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryReason reports whether a failed request is worth retrying, with what
+// went wrong and how long the server asked to wait, if it did.
+func retryReason(err error) (reason string, retryAfter time.Duration, ok bool) {
+	status, header := 0, http.Header(nil)
+	var apiErr *anthropic.Error
+	var providerErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.StatusCode
+		if apiErr.Response != nil {
+			header = apiErr.Response.Header
+		}
+	case errors.As(err, &providerErr):
+		status, header = providerErr.StatusCode, providerErr.Header
+	}
+	if status != 0 {
+		switch {
+		case status == http.StatusTooManyRequests:
+			reason = "rate limited"
+		case status == 529:
+			reason = "the API is overloaded"
+		case status >= 500:
+			reason = fmt.Sprintf("the API failed with %d %s", status, http.StatusText(status))
+		default:
+			return "", 0, false
+		}
+		return reason, parseRetryAfter(header), true
+	}
+
+	// errors sent in the middle of a stream only have the error's type
+	message := err.Error()
+	if strings.HasPrefix(message, "received error while streaming") {
+		for _, errorType := range []string{"overloaded_error", "rate_limit_error", "api_error"} {
+			if strings.Contains(message, errorType) {
+				return "the stream failed with " + errorType, 0, true
+			}
+		}
+		return "", 0, false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return "network error", 0, true
+	}
+	return "", 0, false
+}
+
+// parseRetryAfter reads how long the server asked to wait from the
+// retry-after-ms or retry-after header, which holds seconds or an HTTP date.
+func parseRetryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// countdown waits for delay, showing the message and the seconds left on one
+// line. It returns early with the context's error when ctx is done.
+func countdown(ctx context.Context, delay time.Duration, message string) error {
+	deadline := time.Now().Add(delay)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			fmt.Print("\r\u001b[K")
+			return nil
+		}
+		fmt.Printf("\r\u001b[K%s in %ds", message, int(math.Ceil(remaining.Seconds())))
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return ctx.Err()
+		case <-time.After(min(remaining, time.Second)):
+		}
+	}
+}
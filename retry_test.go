@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+const testStreamResponse = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"test","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":0}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hello"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+// newRetryTestAgent returns an agent talking to a local server that answers
+// requests with the responses in order, repeating the last one, and a
+// counter of the requests it got.
+func newRetryTestAgent(t *testing.T, responses ...func(w http.ResponseWriter)) (*Agent, *atomic.Int32) {
+	t.Helper()
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		responses[min(n, len(responses))-1](w)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewAnthropicProvider(option.WithBaseURL(server.URL), option.WithAPIKey("test"))
+	agent := NewAgent(provider, nil, nil, DefaultConfig(), nil, nil)
+	agent.retryPolicy = RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	return agent, requests
+}
+
+func respondStatus(status int, headers ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"type":"error","error":{"type":"error","message":"status %d"}}`, status)
+	}
+}
+
+func respondStream(body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, body)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("hi"))}
+
+	// happy path: overloaded, rate limited and failed requests are retried until one succeeds
+	agent, requests := newRetryTestAgent(t,
+		respondStatus(529),
+		respondStatus(429, "Retry-After-Ms", "20"),
+		respondStream("event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"),
+		respondStream(testStreamResponse),
+	)
+	message, err := agent.runInference(context.Background(), conversation)
+	if err != nil {
+		t.Fatalf("failed to run inference: %v", err)
+	}
+	if requests.Load() != 4 || message.Content[0].Text != "hello" {
+		t.Fatalf("expected hello after 4 requests, got %q after %d", message.Content[0].Text, requests.Load())
+	}
+	if agent.totalUsage.InputTokens != 10 {
+		t.Fatalf("expected only the successful request's usage, got %+v", agent.totalUsage)
+	}
+
+	// test errors that won't go away are not retried
+	agent, requests = newRetryTestAgent(t, respondStatus(400))
+	_, err = agent.runInference(context.Background(), conversation)
+	if err == nil || requests.Load() != 1 {
+		t.Fatalf("expected an error after 1 request, got %v after %d", err, requests.Load())
+	}
+
+	// test giving up after the last retry
+	agent, requests = newRetryTestAgent(t, respondStatus(500))
+	_, err = agent.runInference(context.Background(), conversation)
+	if err == nil || requests.Load() != 4 {
+		t.Fatalf("expected an error after 4 requests, got %v after %d", err, requests.Load())
+	}
+
+	// test an interruption stops the countdown
+	agent, requests = newRetryTestAgent(t, respondStatus(503, "Retry-After", "60"))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = agent.runInference(ctx, conversation)
+	if err != context.Canceled || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected the wait to be cancelled, got %v after %s", err, time.Since(start))
+	}
+}
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		content, _ := io.ReadAll(r)
+		output <- string(content)
+	}()
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-output
+}
+
+func TestRetryDiscardsPartialText(t *testing.T) {
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("hi"))}
+	cutOff := strings.Split(testStreamResponse, "event: content_block_stop")[0] +
+		"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+	agent, requests := newRetryTestAgent(t, respondStream(cutOff), respondStream(testStreamResponse))
+
+	// happy path: the text streamed before the failure is marked as discarded before the retry prints it again
+	var err error
+	output := captureStdout(t, func() {
+		_, err = agent.runInference(context.Background(), conversation)
+	})
+	if err != nil || requests.Load() != 2 {
+		t.Fatalf("expected success after 2 requests, got %v after %d", err, requests.Load())
+	}
+	discarded := strings.Index(output, "discarded")
+	if strings.Count(output, "hello") != 2 || discarded == -1 || discarded > strings.LastIndex(output, "hello") {
+		t.Fatalf("expected the first hello to be marked as discarded, got %q", output)
+	}
+}
+
+func TestRunAfterGivingUp(t *testing.T) {
+	agent, _ := newRetryTestAgent(t, respondStatus(529))
+	prompts := []string{"hi"}
+	agent.getUserMessage = func() (string, bool) {
+		if len(prompts) == 0 {
+			return "", false
+		}
+		prompt := prompts[0]
+		prompts = prompts[1:]
+		return prompt, true
+	}
+
+	// happy path: the session goes on, and the failed turn gets a reply
+	err := agent.Run(context.Background())
+	if err != nil {
+		t.Fatalf("expected the session to go on, got %v", err)
+	}
+	if len(agent.conversation) != 2 || agent.conversation[1].Role != anthropic.MessageParamRoleAssistant {
+		t.Fatalf("expected the prompt and a reply, got %+v", agent.conversation)
+	}
+	reply := agent.conversation[1].Content[0].OfText.Text
+	if !strings.HasPrefix(reply, "(the request failed: ") || !strings.Contains(reply, "529") {
+		t.Fatalf("unexpected reply: %q", reply)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header   http.Header
+		expected time.Duration
+	}{
+		{http.Header{"Retry-After": {"2"}}, 2 * time.Second},
+		{http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"2"}}, 1500 * time.Millisecond},
+		{http.Header{"Retry-After": {"soon"}}, 0},
+		{http.Header{"Retry-After": {time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}}, 0},
+		{http.Header{}, 0},
+	}
+	for _, test := range tests {
+		if delay := parseRetryAfter(test.header); delay != test.expected {
+			t.Fatalf("expected %s for %v, got %s", test.expected, test.header, delay)
+		}
+	}
+
+	// test the backoff grows but stays jittered within its bounds
+	policy := RetryPolicy{MaxRetries: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, bound := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay := policy.backoff(attempt)
+		if delay < bound/2 || delay > bound {
+			t.Fatalf("expected a delay between %s and %s for attempt %d, got %s", bound/2, bound, attempt, delay)
+		}
+	}
+}
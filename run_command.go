@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	// commandTimeout is how long run_command lets a command run when the call doesn't ask for a timeout.
+	commandTimeout = 2 * time.Minute
+	// maxCommandTimeout caps the timeout a call may ask for.
+	maxCommandTimeout = 10 * time.Minute
+	// maxCommandOutput is how many bytes of stdout and of stderr are returned to the model.
+	maxCommandOutput = 16 * 1024
+)
+
+var RunCommandDefinition = ToolDefinition{
+	Name: "run_command",
+	Description: `Run a shell command in the workspace root and return its exit code, stdout and stderr.
+
+Use this to build, test and inspect the project, e.g. 'go build ./...', 'go test ./...' or 'git diff'.
+
+The command runs with 'sh -c'. It is killed, along with any processes it started, if it runs longer than the timeout. Long output is truncated in the middle.
+
+The user must approve commands before they run.
+`,
+	InputSchema: RunCommandInputSchema,
+	Function:    RunCommand,
+	Mutating:    true,
+	// the command's own timeout, at most maxCommandTimeout, comes first
+	Timeout: maxCommandTimeout + time.Minute,
+}
+
+type RunCommandInput struct {
+	Command        string `json:"command" jsonschema_description:"The shell command to run"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" jsonschema_description:"Optional timeout in seconds. Defaults to the configured command timeout."`
+}
+
+var RunCommandInputSchema = GenerateSchema[RunCommandInput]()
+
+func RunCommand(ctx context.Context, input json.RawMessage) (string, error) {
+	runCommandInput := RunCommandInput{}
+	err := json.Unmarshal(input, &runCommandInput)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(runCommandInput.Command) == "" {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+
+	timeout := commandTimeout
+	if runCommandInput.TimeoutSeconds > 0 {
+		timeout = time.Duration(runCommandInput.TimeoutSeconds) * time.Second
+	}
+	if timeout > maxCommandTimeout {
+		timeout = maxCommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", runCommandInput.Command)
+	cmd.Dir = workspace.Root()
+	stdout := newCappedOutput(maxCommandOutput)
+	stderr := newCappedOutput(maxCommandOutput)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// run the command in its own process group so a timeout kills everything it started
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	// don't wait forever on output pipes held open by orphaned children
+	cmd.WaitDelay = 5 * time.Second
+
+	err = cmd.Run()
+
+	exitCode := 0
+	status := ""
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.Canceled:
+		return "", ctx.Err()
+	case ctx.Err() == context.DeadlineExceeded:
+		exitCode = -1
+		status = fmt.Sprintf(" (killed after timing out after %s)", timeout)
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+	case err != nil:
+		return "", err
+	}
+
+	return fmt.Sprintf("exit code: %d%s\nstdout:\n%s\nstderr:\n%s",
+		exitCode, status,
+		stdout.String(),
+		stderr.String(),
+	), nil
+}
+
+// truncateOutput keeps the start and the end of output longer than limit bytes,
+// since both the command's first errors and its final summary tend to matter.
+func truncateOutput(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+	head := limit / 3
+	tail := limit - head
+	omitted := len(output) - head - tail
+	return fmt.Sprintf("%s\n... [%d bytes omitted] ...\n%s", output[:head], omitted, output[len(output)-tail:])
+}
+
+// cappedOutput collects a command's output like truncateOutput would cut it,
+// keeping only its start and end as it is written, so a command printing
+// without end can't use up the memory before it times out.
+type cappedOutput struct {
+	headLimit int
+	tailLimit int
+	head      []byte
+	tail      []byte
+	omitted   int
+}
+
+func newCappedOutput(limit int) *cappedOutput {
+	head := limit / 3
+	return &cappedOutput{headLimit: head, tailLimit: limit - head}
+}
+
+func (o *cappedOutput) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := o.headLimit - len(o.head); room > 0 {
+		k := min(room, len(p))
+		o.head = append(o.head, p[:k]...)
+		p = p[k:]
+	}
+	if len(p) >= o.tailLimit {
+		o.omitted += len(o.tail) + len(p) - o.tailLimit
+		o.tail = append(o.tail[:0], p[len(p)-o.tailLimit:]...)
+		return n, nil
+	}
+	o.tail = append(o.tail, p...)
+	if extra := len(o.tail) - o.tailLimit; extra > 0 {
+		o.omitted += extra
+		o.tail = append(o.tail[:0], o.tail[extra:]...)
+	}
+	return n, nil
+}
+
+func (o *cappedOutput) String() string {
+	if o.omitted == 0 {
+		return string(o.head) + string(o.tail)
+	}
+	return fmt.Sprintf("%s\n... [%d bytes omitted] ...\n%s", o.head, o.omitted, o.tail)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	// happy path
+	runCommandInput := json.RawMessage(`{
+		"command": "echo hello && echo oops >&2"
+	}`)
+	result, err := RunCommand(context.Background(), runCommandInput)
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	expected := "exit code: 0\nstdout:\nhello\n\nstderr:\noops\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test non-zero exit code
+	runCommandInput = json.RawMessage(`{
+		"command": "exit 3"
+	}`)
+	result, err = RunCommand(context.Background(), runCommandInput)
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	if !strings.HasPrefix(result, "exit code: 3\n") {
+		t.Fatalf("expected exit code 3, got %s", result)
+	}
+
+	// test timeout kills the command and the processes it started
+	runCommandInput = json.RawMessage(`{
+		"command": "sleep 30 & sleep 30",
+		"timeout_seconds": 1
+	}`)
+	start := time.Now()
+	result, err = RunCommand(context.Background(), runCommandInput)
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	if !strings.HasPrefix(result, "exit code: -1 (killed after timing out") {
+		t.Fatalf("expected a timeout, got %s", result)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("expected the command to be killed quickly, took %s", time.Since(start))
+	}
+
+	// test empty command
+	runCommandInput = json.RawMessage(`{
+		"command": ""
+	}`)
+	_, err = RunCommand(context.Background(), runCommandInput)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestTruncateOutput(t *testing.T) {
+	output := strings.Repeat("a", 50) + strings.Repeat("b", 50)
+	result := truncateOutput(output, 30)
+	if !strings.HasPrefix(result, strings.Repeat("a", 10)+"\n... [70 bytes omitted] ...\n") || !strings.HasSuffix(result, strings.Repeat("b", 20)) {
+		t.Fatalf("unexpected truncation: %q", result)
+	}
+
+	// test short output is untouched
+	if truncateOutput("short", 30) != "short" {
+		t.Fatalf("expected short output to be unchanged")
+	}
+
+	// test output capped while it is written is cut the same way, whatever the size of the writes
+	for _, chunk := range []int{1, 7, 30, 100} {
+		capped := newCappedOutput(30)
+		for i := 0; i < len(output); i += chunk {
+			capped.Write([]byte(output[i:min(i+chunk, len(output))]))
+		}
+		if capped.String() != result {
+			t.Fatalf("expected %q for writes of %d bytes, got %q", result, chunk, capped.String())
+		}
+	}
+	capped := newCappedOutput(30)
+	capped.Write([]byte("short"))
+	if capped.String() != "short" {
+		t.Fatalf("expected short output to be unchanged, got %q", capped.String())
+	}
+
+	// test a command printing without end only keeps the capped output
+	result, err := RunCommand(context.Background(), json.RawMessage(`{"command": "yes | head -c 50000000"}`))
+	if err != nil {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	if len(result) > 2*maxCommandOutput+200 || !strings.Contains(result, " bytes omitted] ...") {
+		t.Fatalf("expected the output to be capped, got %d bytes", len(result))
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultSearchResults is how many matching lines a search returns when the
+// model doesn't ask for a different cap.
+const defaultSearchResults = 100
+
+// maxSearchFileSize is the largest file search reads. Bigger files are usually
+// generated or data, and would hold up the search.
+const maxSearchFileSize = 1024 * 1024
+
+// maxSearchLineLength is where long lines, e.g. in minified files, are cut.
+const maxSearchLineLength = 500
+
+var SearchDefinition = ToolDefinition{
+	Name: "search",
+	Description: `Search the contents of files with a regular expression.
+
+Uses Go regexp syntax (RE2). Searches 'path' (a file, or a directory searched recursively), defaulting to the workspace root. Binary files, files over 1 MiB, .git directories and files excluded by .gitignore are skipped.
+
+Results are grouped by file. Matching lines are printed as '<line>:<text>' and context lines as '<line>-<text>', with '--' between groups of lines that aren't next to each other when context is shown.
+
+Use 'include' and 'exclude' globs to narrow the files searched, e.g. "*.go" or "internal/**/*_test.go". A glob without a '/' matches file names in any directory.
+`,
+	InputSchema: SearchInputSchema,
+	Function:    Search,
+}
+
+type SearchInput struct {
+	Pattern         string   `json:"pattern" jsonschema_description:"The regular expression to search for"`
+	Path            string   `json:"path,omitempty" jsonschema_description:"Optional file or directory to search. Defaults to the workspace root."`
+	Include         []string `json:"include,omitempty" jsonschema_description:"Optional globs; only files matching one of them are searched"`
+	Exclude         []string `json:"exclude,omitempty" jsonschema_description:"Optional globs; files matching any of them are not searched"`
+	CaseInsensitive bool     `json:"case_insensitive,omitempty" jsonschema_description:"Match regardless of case"`
+	BeforeContext   int      `json:"before_context,omitempty" jsonschema_description:"Number of lines to show before each match"`
+	AfterContext    int      `json:"after_context,omitempty" jsonschema_description:"Number of lines to show after each match"`
+	MaxResults      int      `json:"max_results,omitempty" jsonschema_description:"Maximum number of matching lines to return. Defaults to 100."`
+}
+
+var SearchInputSchema = GenerateSchema[SearchInput]()
+
+func Search(ctx context.Context, input json.RawMessage) (string, error) {
+	searchInput := SearchInput{}
+	err := json.Unmarshal(input, &searchInput)
+	if err != nil {
+		return "", err
+	}
+
+	if searchInput.Pattern == "" || searchInput.BeforeContext < 0 || searchInput.AfterContext < 0 || searchInput.MaxResults < 0 {
+		return "", fmt.Errorf("invalid input parameters")
+	}
+	maxResults := searchInput.MaxResults
+	if maxResults == 0 {
+		maxResults = defaultSearchResults
+	}
+
+	pattern := searchInput.Pattern
+	if searchInput.CaseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	root, err := workspace.Resolve(searchInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	output := strings.Builder{}
+	results := 0
+	truncated := false
+	unreadable := []string{}
+	tooLarge := 0
+	err = walkFiles(root, true, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if path == root {
+				return err
+			}
+			unreadable = append(unreadable, filepath.ToSlash(workspace.Rel(path)))
+			return skipWalkError(d)
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel := filepath.ToSlash(workspace.Rel(path))
+		if !searchIncludes(searchInput.Include, searchInput.Exclude, rel) {
+			return nil
+		}
+
+		content, large, err := readSearchFile(path)
+		if err != nil {
+			unreadable = append(unreadable, rel)
+			return nil
+		}
+		if large {
+			tooLarge++
+			return nil
+		}
+		if content == nil {
+			return nil
+		}
+
+		matches, count, more := searchFile(re, content, maxResults-results, searchInput.BeforeContext, searchInput.AfterContext)
+		if count > 0 {
+			if output.Len() > 0 {
+				output.WriteString("\n")
+			}
+			output.WriteString(rel + "\n" + matches)
+			results += count
+		}
+		if more {
+			truncated = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	notes := walkErrorNote(unreadable)
+	if tooLarge > 0 {
+		notes += fmt.Sprintf("(skipped %d file(s) over %d KiB)\n", tooLarge, maxSearchFileSize/1024)
+	}
+	if output.Len() == 0 {
+		if notes != "" {
+			return "No matches found\n" + notes, nil
+		}
+		return "No matches found", nil
+	}
+	if truncated {
+		output.WriteString(fmt.Sprintf("\n(results truncated at %d matching lines; narrow the search to see more)\n", maxResults))
+	}
+	if notes != "" {
+		output.WriteString("\n" + notes)
+	}
+	return output.String(), nil
+}
+
+// readSearchFile reads a file to search. It returns nil content, without
+// reading the whole file, if the file is binary or not a regular file, and
+// large if it is over maxSearchFileSize.
+func readSearchFile(path string) (content []byte, large bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, false, nil
+	}
+	if info.Size() > maxSearchFileSize {
+		return nil, true, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	head := make([]byte, 8000)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	if isBinary(head[:n]) {
+		return nil, false, nil
+	}
+	// the file may have grown since it was checked
+	rest, err := io.ReadAll(io.LimitReader(file, maxSearchFileSize-int64(n)))
+	if err != nil {
+		return nil, false, err
+	}
+	return append(head[:n], rest...), false, nil
+}
+
+// searchFile returns the matching lines of content, at most limit of them,
+// with their context lines. count is the number of matching lines returned and
+// more is true if there were more.
+func searchFile(re *regexp.Regexp, content []byte, limit, before, after int) (result string, count int, more bool) {
+	lines := strings.Split(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	matched := []int{}
+	for i, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(matched) == limit {
+			more = true
+			break
+		}
+		matched = append(matched, i)
+	}
+
+	output := strings.Builder{}
+	printed := -1
+	for j, i := range matched {
+		start := max(i-before, printed+1)
+		if printed >= 0 && start > printed+1 && (before > 0 || after > 0) {
+			output.WriteString("--\n")
+		}
+		end := min(i+after, len(lines)-1)
+		// stop the context before the next match, which prints its own
+		if j+1 < len(matched) {
+			end = min(end, matched[j+1]-1)
+		}
+		for k := start; k <= end; k++ {
+			separator := "-"
+			if k == i {
+				separator = ":"
+			}
+			output.WriteString(fmt.Sprintf("%d%s%s\n", k+1, separator, truncateLine(strings.TrimSuffix(lines[k], "\r"))))
+		}
+		printed = end
+	}
+	return output.String(), len(matched), more
+}
+
+func searchIncludes(include, exclude []string, rel string) bool {
+	for _, pattern := range exclude {
+		if matchPathGlob(pattern, rel) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if matchPathGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBinary guesses whether content is binary the way git does, by looking for
+// a NUL byte near the start.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) != -1
+}
+
+func truncateLine(line string) string {
+	if len(line) <= maxSearchLineLength {
+		return line
+	}
+	return line[:maxSearchLineLength] + "... (line truncated)"
+}
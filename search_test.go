@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	defer func(previous *Workspace) { workspace = previous }(workspace)
+	workspace = w
+
+	root := w.Root()
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("ignored.go\n"), 0644)
+	os.WriteFile(filepath.Join(root, "a.go"), []byte("package a\n\nfunc One() {}\n\nfunc Two() {}\nfunc Three() {}\n"), 0644)
+	os.WriteFile(filepath.Join(root, "a_test.go"), []byte("package a\n\nfunc TestOne() {}\n"), 0644)
+	os.WriteFile(filepath.Join(root, "ignored.go"), []byte("func Ignored() {}\n"), 0644)
+	os.WriteFile(filepath.Join(root, "data.bin"), []byte("func\x00binary\n"), 0644)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.go"), []byte("func Secret() {}\n"), 0644)
+	os.Symlink(filepath.Join(outside, "secret.go"), filepath.Join(root, "leak.go"))
+	os.Symlink(filepath.Join(root, "missing.go"), filepath.Join(root, "dangling.go"))
+
+	// happy path: context lines of nearby matches are merged
+	input := json.RawMessage(`{"pattern": "^func (One|Three)", "before_context": 1, "after_context": 1}`)
+	result, err := Search(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	expected := "a.go\n2-\n3:func One() {}\n4-\n5-func Two() {}\n6:func Three() {}\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test symlinks out of the workspace aren't followed, and dangling ones don't fail the search
+	input = json.RawMessage(`{"pattern": "Secret"}`)
+	result, err = Search(context.Background(), input)
+	if err != nil || result != "No matches found" {
+		t.Fatalf("expected no matches, got %q and %v", result, err)
+	}
+
+	// test groups that aren't next to each other are separated
+	input = json.RawMessage(`{"pattern": "package|Three", "include": ["a.go"], "before_context": 1}`)
+	result, err = Search(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	expected = "a.go\n1:package a\n--\n5-func Two() {}\n6:func Three() {}\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test globs, case-insensitivity and that ignored and binary files are skipped
+	input = json.RawMessage(`{"pattern": "FUNC", "case_insensitive": true, "exclude": ["*_test.go"]}`)
+	result, err = Search(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	expected = "a.go\n3:func One() {}\n5:func Two() {}\n6:func Three() {}\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+	input = json.RawMessage(`{"pattern": "func", "include": ["*_test.go"]}`)
+	result, err = Search(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	expected = "a_test.go\n3:func TestOne() {}\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test the results cap
+	input = json.RawMessage(`{"pattern": "func", "max_results": 2}`)
+	result, err = Search(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if !strings.HasPrefix(result, "a.go\n3:func One() {}\n5:func Two() {}\n\n(results truncated at 2") {
+		t.Fatalf("expected truncated results, got %q", result)
+	}
+
+	// test no matches
+	input = json.RawMessage(`{"pattern": "nothing here"}`)
+	result, err = Search(context.Background(), input)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if result != "No matches found" {
+		t.Fatalf("expected No matches found, got %q", result)
+	}
+
+	// test an invalid pattern
+	input = json.RawMessage(`{"pattern": "func ("}`)
+	_, err = Search(context.Background(), input)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestSearchSkipsUnreadable(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	defer func(previous *Workspace) { workspace = previous }(workspace)
+	workspace = w
+
+	root := w.Root()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("needle\n"), 0644)
+	os.WriteFile(filepath.Join(root, "big.txt"), []byte("needle\n"+strings.Repeat("x", maxSearchFileSize)), 0644)
+	newUnreadableDir(t, root, "deep")
+
+	// happy path: a directory that can't be read is left out and noted, and the rest is searched
+	result, err := Search(context.Background(), json.RawMessage(`{"pattern": "needle"}`))
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if !strings.HasPrefix(result, "a.txt\n1:needle\n\n(could not read 1 path(s): deep/") {
+		t.Fatalf("expected the match and a note on the unreadable directory, got %q", result)
+	}
+
+	// test files over the size cap are skipped and counted
+	if !strings.HasSuffix(result, "(skipped 1 file(s) over 1024 KiB)\n") {
+		t.Fatalf("expected a note on the skipped file, got %q", result)
+	}
+}
+
+// newUnreadableDir makes a directory under root that can't be listed, even
+// by root, by nesting directories in it until their path is longer than the
+// system allows.
+func newUnreadableDir(t *testing.T, root, name string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get the working directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	segment := filepath.Join(root, name)
+	for i := 0; i <= 20; i++ {
+		err = os.Mkdir(segment, 0755)
+		if err == nil {
+			err = os.Chdir(segment)
+		}
+		if err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		segment = strings.Repeat("d", 250)
+	}
+}
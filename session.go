@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// SessionStore keeps conversations on disk as one JSONL file per session,
+// with one line per message so tool_use and tool_result turns survive a crash.
+type SessionStore struct {
+	dir string
+}
+
+func NewSessionStore(dir string) *SessionStore {
+	return &SessionStore{dir: dir}
+}
+
+type Session struct {
+	ID      string
+	store   *SessionStore
+	path    string
+	history []anthropic.MessageParam
+}
+
+type sessionRecord struct {
+	Time    time.Time              `json:"time"`
+	Message anthropic.MessageParam `json:"message"`
+}
+
+// SessionInfo summarizes a stored session for listing.
+type SessionInfo struct {
+	ID          string
+	Started     time.Time
+	Updated     time.Time
+	FirstPrompt string
+	Messages    int
+}
+
+// Create starts a new, empty session. Its file is only written once the first message is appended.
+func (s *SessionStore) Create() (*Session, error) {
+	suffix := make([]byte, 3)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+	id := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+	return &Session{ID: id, store: s, path: s.path(id)}, nil
+}
+
+// Open loads an existing session along with its conversation history.
+func (s *SessionStore) Open(id string) (*Session, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid session id %q", id)
+	}
+	records, err := readSessionRecords(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("session %s not found", id)
+		}
+		return nil, err
+	}
+
+	history := make([]anthropic.MessageParam, 0, len(records))
+	for _, record := range records {
+		history = append(history, record.Message)
+	}
+	return &Session{ID: id, store: s, path: s.path(id), history: history}, nil
+}
+
+// Latest returns the ID of the most recently updated session.
+func (s *SessionStore) Latest() (string, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	if len(sessions) == 0 {
+		return "", fmt.Errorf("no sessions found in %s", s.dir)
+	}
+	return sessions[0].ID, nil
+}
+
+// List returns all stored sessions, most recently updated first.
+func (s *SessionStore) List() ([]SessionInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SessionInfo{}, nil
+		}
+		return nil, err
+	}
+
+	sessions := []SessionInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+		records, err := readSessionRecords(filepath.Join(s.dir, entry.Name()))
+		if err != nil || len(records) == 0 {
+			continue
+		}
+
+		info := SessionInfo{
+			ID:       strings.TrimSuffix(entry.Name(), ".jsonl"),
+			Started:  records[0].Time,
+			Updated:  records[len(records)-1].Time,
+			Messages: len(records),
+		}
+		for _, record := range records {
+			if prompt := messageText(record.Message); record.Message.Role == anthropic.MessageParamRoleUser && prompt != "" {
+				info.FirstPrompt = prompt
+				break
+			}
+		}
+		sessions = append(sessions, info)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+func (s *SessionStore) path(id string) string {
+	return filepath.Join(s.dir, id+".jsonl")
+}
+
+// History returns the conversation loaded when the session was opened.
+func (s *Session) History() []anthropic.MessageParam {
+	return s.history
+}
+
+// Append writes one message to the end of the session file.
+func (s *Session) Append(message anthropic.MessageParam) error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writeSessionRecord(file, sessionRecord{Time: time.Now(), Message: message})
+}
+
+// Rewrite replaces the session file with the given conversation. It is used when
+// the history is changed in place rather than appended to.
+func (s *Session) Rewrite(conversation []anthropic.MessageParam) error {
+	err := os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, message := range conversation {
+		err = writeSessionRecord(file, sessionRecord{Time: now, Message: message})
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+func writeSessionRecord(file *os.File, record sessionRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func readSessionRecords(path string) ([]sessionRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []sessionRecord{}
+	scanner := bufio.NewScanner(file)
+	// tool results can be whole files, so allow for very long lines
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		record := sessionRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// messageText joins the text blocks of a message, ignoring tool blocks.
+func messageText(message anthropic.MessageParam) string {
+	texts := []string{}
+	for _, block := range message.Content {
+		if block.OfText != nil {
+			texts = append(texts, block.OfText.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// interruptedToolResults checks whether a loaded history ends with tool_use blocks
+// that never got results, which happens when a session is killed while tools are
+// running. If so it returns a message answering each of them with an error, so the
+// conversation is valid to send again.
+func interruptedToolResults(conversation []anthropic.MessageParam) (anthropic.MessageParam, bool) {
+	if len(conversation) == 0 {
+		return anthropic.MessageParam{}, false
+	}
+	last := conversation[len(conversation)-1]
+	if last.Role != anthropic.MessageParamRoleAssistant {
+		return anthropic.MessageParam{}, false
+	}
+
+	toolResults := []anthropic.ContentBlockParamUnion{}
+	for _, block := range last.Content {
+		if block.OfToolUse != nil {
+			toolResults = append(toolResults, anthropic.NewToolResultBlock(block.OfToolUse.ID, "tool call was interrupted before it finished", true))
+		}
+	}
+	if len(toolResults) == 0 {
+		return anthropic.MessageParam{}, false
+	}
+	return anthropic.NewUserMessage(toolResults...), true
+}
+
+func printSessions(store *SessionStore) error {
+	sessions, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions found")
+		return nil
+	}
+	for _, session := range sessions {
+		prompt := shortenPrompt(session.FirstPrompt, 60)
+		fmt.Printf("%s  %s  %3d messages  %s\n", session.ID, session.Started.Local().Format("2006-01-02 15:04"), session.Messages, prompt)
+	}
+	return nil
+}
+
+// shortenPrompt puts a prompt on one line and cuts it to at most limit
+// characters, counting runes so that a character is never cut in half.
+func shortenPrompt(prompt string, limit int) string {
+	runes := []rune(strings.Join(strings.Fields(prompt), " "))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+	return string(runes[:limit-3]) + "..."
+}
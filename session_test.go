@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore(t.TempDir())
+
+	// test an empty store
+	_, err := store.Latest()
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// happy path: write a turn with a tool call and read it back
+	session, err := store.Create()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	conversation := []anthropic.MessageParam{
+		anthropic.NewUserMessage(anthropic.NewTextBlock("read test.txt")),
+		anthropic.NewAssistantMessage(anthropic.NewToolUseBlock("toolu_1", json.RawMessage(`{"path":"test.txt"}`), "read_file")),
+		anthropic.NewUserMessage(anthropic.NewToolResultBlock("toolu_1", "test1", false)),
+		anthropic.NewAssistantMessage(anthropic.NewTextBlock("The file says test1.")),
+	}
+	for _, message := range conversation {
+		err = session.Append(message)
+		if err != nil {
+			t.Fatalf("failed to append message: %v", err)
+		}
+	}
+
+	resumed, err := store.Open(session.ID)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	expected, _ := json.Marshal(conversation)
+	got, _ := json.Marshal(resumed.History())
+	if string(expected) != string(got) {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	// test listing shows the first prompt
+	sessions, err := store.List()
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != session.ID || sessions[0].FirstPrompt != "read test.txt" || sessions[0].Messages != 4 {
+		t.Fatalf("unexpected session listing: %+v", sessions)
+	}
+
+	// test rewriting replaces the history
+	err = session.Rewrite(conversation[:2])
+	if err != nil {
+		t.Fatalf("failed to rewrite session: %v", err)
+	}
+	resumed, err = store.Open(session.ID)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	if len(resumed.History()) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(resumed.History()))
+	}
+
+	// test a session that stopped mid tool call gets an error result for it
+	toolResults, ok := interruptedToolResults(resumed.History())
+	if !ok {
+		t.Fatalf("expected interrupted tool results")
+	}
+	if len(toolResults.Content) != 1 || toolResults.Content[0].OfToolResult == nil || toolResults.Content[0].OfToolResult.ToolUseID != "toolu_1" {
+		t.Fatalf("unexpected tool results: %+v", toolResults)
+	}
+
+	// test missing and invalid session ids
+	_, err = store.Open("missing")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	_, err = store.Open("../missing")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}
+
+func TestShortenPrompt(t *testing.T) {
+	// happy path: short prompts are put on one line
+	if prompt := shortenPrompt("fix\n  the bug", 60); prompt != "fix the bug" {
+		t.Fatalf("expected %q, got %q", "fix the bug", prompt)
+	}
+
+	// test long prompts are cut by characters, not bytes
+	prompt := shortenPrompt(strings.Repeat("é", 70), 60)
+	if !utf8.ValidString(prompt) || prompt != strings.Repeat("é", 57)+"..." {
+		t.Fatalf("expected 57 characters and an ellipsis, got %q", prompt)
+	}
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
 )
 
 func TestReadLines(t *testing.T) {
@@ -14,7 +22,7 @@ func TestReadLines(t *testing.T) {
 		"start_line": 1,
 		"end_line": 2
 	}`)
-	result, err := ReadLines(readLinesInput)
+	result, err := ReadLines(context.Background(), readLinesInput)
 	if err != nil {
 		t.Fatalf("failed to read lines: %v", err)
 	}
@@ -37,7 +45,7 @@ func TestReadLines(t *testing.T) {
 		"start_line": 2,
 		"end_line": 2
 	}`)
-	result, err = ReadLines(readLinesInput)
+	result, err = ReadLines(context.Background(), readLinesInput)
 	if err != nil {
 		t.Fatalf("failed to read lines: %v", err)
 	}
@@ -58,7 +66,7 @@ func TestReadLines(t *testing.T) {
 		"start_line": 3,
 		"end_line": 2
 	}`)
-	_, err = ReadLines(readLinesInput)
+	_, err = ReadLines(context.Background(), readLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -69,7 +77,7 @@ func TestReadLines(t *testing.T) {
 		"start_line": 1,
 		"end_line": 5
 	}`)
-	_, err = ReadLines(readLinesInput)
+	_, err = ReadLines(context.Background(), readLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -80,7 +88,7 @@ func TestReadLines(t *testing.T) {
 		"start_line": 1,
 		"end_line": 4
 	}`)
-	result, err = ReadLines(readLinesInput)
+	result, err = ReadLines(context.Background(), readLinesInput)
 	if err != nil {
 		t.Fatalf("failed to read lines: %v", err)
 	}
@@ -101,7 +109,7 @@ func TestReadLines(t *testing.T) {
 		"start_line": 1,
 		"end_line":   2
 	}`)
-	_, err = ReadLines(readLinesInput)
+	_, err = ReadLines(context.Background(), readLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -118,12 +126,13 @@ func TestEditFile(t *testing.T) {
 		"old_str": "test1",
 		"new_str": "test10"
 	}`)
-	result, err := EditFile(editFileInput)
+	result, err := EditFile(context.Background(), editFileInput)
 	if err != nil {
 		t.Fatalf("failed to edit file: %v", err)
 	}
-	if result != "OK" {
-		t.Fatalf("expected OK, got %s", result)
+	expectedResult := "OK\n--- a/test_edit.txt\n+++ b/test_edit.txt\n@@ -1,2 +1,2 @@\n-test1\n+test10\n test2\n"
+	if result != expectedResult {
+		t.Fatalf("expected %q, got %q", expectedResult, result)
 	}
 	fileContent, err := os.ReadFile("test_edit.txt")
 	if err != nil {
@@ -139,7 +148,7 @@ func TestEditFile(t *testing.T) {
 		"old_str": "test1",
 		"new_str": "test10"
 	}`)
-	_, err = EditFile(editFileInput)
+	_, err = EditFile(context.Background(), editFileInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -150,7 +159,7 @@ func TestEditFile(t *testing.T) {
 		"old_str": "",
 		"new_str": "test123"
 	}`)
-	_, err = EditFile(editFileInput)
+	_, err = EditFile(context.Background(), editFileInput)
 	if err != nil {
 		t.Fatalf("failed to edit file: %v", err)
 	}
@@ -169,7 +178,7 @@ func TestEditFile(t *testing.T) {
 		"old_str": "test",
 		"new_str": "test99"
 	}`)
-	_, err = EditFile(editFileInput)
+	_, err = EditFile(context.Background(), editFileInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -180,7 +189,7 @@ func TestEditFile(t *testing.T) {
 		"old_str": "test1",
 		"new_str": "test1"
 	}`)
-	_, err = EditFile(editFileInput)
+	_, err = EditFile(context.Background(), editFileInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -191,7 +200,7 @@ func TestEditFile(t *testing.T) {
 		"old_str": "",
 		"new_str": "test1"
 	}`)
-	_, err = EditFile(editFileInput)
+	_, err = EditFile(context.Background(), editFileInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -202,7 +211,7 @@ func TestEditFile(t *testing.T) {
 		"old_str": "test1",
 		"new_str": "test10"
 	}`)
-	_, err = EditFile(editFileInput)
+	_, err = EditFile(context.Background(), editFileInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -219,12 +228,13 @@ func TestDeleteLines(t *testing.T) {
 		"start_line": 1,
 		"end_line": 3
 	}`)
-	result, err := DeleteLines(deleteLinesInput)
+	result, err := DeleteLines(context.Background(), deleteLinesInput)
 	if err != nil {
 		t.Fatalf("failed to delete lines: %v", err)
 	}
-	if result != "OK" {
-		t.Fatalf("expected OK, got %s", result)
+	expectedResult := "OK\n--- a/test_delete.txt\n+++ b/test_delete.txt\n@@ -1,4 +1 @@\n-test1\n-test2\n-test3\n test4\n"
+	if result != expectedResult {
+		t.Fatalf("expected %q, got %q", expectedResult, result)
 	}
 	fileContent, err := os.ReadFile("test_delete.txt")
 	if err != nil {
@@ -240,7 +250,7 @@ func TestDeleteLines(t *testing.T) {
 		"start_line": 1,
 		"end_line": 6
 	}`)
-	_, err = DeleteLines(deleteLinesInput)
+	_, err = DeleteLines(context.Background(), deleteLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -251,7 +261,7 @@ func TestDeleteLines(t *testing.T) {
 		"start_line": 0,
 		"end_line": 1
 	}`)
-	_, err = DeleteLines(deleteLinesInput)
+	_, err = DeleteLines(context.Background(), deleteLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -262,7 +272,7 @@ func TestDeleteLines(t *testing.T) {
 		"start_line": 1,
 		"end_line": 2
 	}`)
-	_, err = DeleteLines(deleteLinesInput)
+	_, err = DeleteLines(context.Background(), deleteLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
@@ -273,8 +283,292 @@ func TestDeleteLines(t *testing.T) {
 		"start_line": 3,
 		"end_line": 2
 	}`)
-	_, err = DeleteLines(deleteLinesInput)
+	_, err = DeleteLines(context.Background(), deleteLinesInput)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+func TestListFiles(t *testing.T) {
+	w, err := NewWorkspace(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	defer func(previous *Workspace) { workspace = previous }(workspace)
+	workspace = w
+
+	root := w.Root()
+	files := map[string]string{
+		".gitignore":           "node_modules/\n",
+		".env":                 "SECRET=1\n",
+		"main.go":              "package main\n",
+		"internal/a/a.go":      strings.Repeat("x", 1536),
+		"internal/b.go":        "package internal\n",
+		"node_modules/m/x.js":  "x\n",
+		".git/objects/ab/cdef": "x\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+
+	// happy path: a tree with sizes, leaving out hidden and ignored files
+	result, err := ListFiles(context.Background(), json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	expected := "internal/\n  a/\n    a.go (1.5 KB)\n  b.go (17 B)\nmain.go (13 B)\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test max depth
+	result, err = ListFiles(context.Background(), json.RawMessage(`{"max_depth": 1}`))
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	expected = "internal/ (2 entries, not expanded)\nmain.go (13 B)\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test hidden and ignored files can be included, but never .git
+	result, err = ListFiles(context.Background(), json.RawMessage(`{"include_hidden": true, "respect_gitignore": false, "max_depth": 1}`))
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	expected = ".env (9 B)\n.gitignore (14 B)\ninternal/ (2 entries, not expanded)\nmain.go (13 B)\nnode_modules/ (1 entry, not expanded)\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test pagination
+	result, err = ListFiles(context.Background(), json.RawMessage(`{"offset": 1, "limit": 2}`))
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	expected = "  a/\n    a.go (1.5 KB)\n(showing entries 2-3 of 5; use offset 3 to see more)\n"
+	if result != expected {
+		t.Fatalf("expected %q, got %q", expected, result)
+	}
+
+	// test offset past the end
+	_, err = ListFiles(context.Background(), json.RawMessage(`{"offset": 5}`))
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test a directory that can't be read is left out and noted
+	newUnreadableDir(t, root, "deep")
+	result, err = ListFiles(context.Background(), json.RawMessage(`{"path": "deep", "limit": 1000}`))
+	if err != nil || !strings.HasPrefix(result, strings.Repeat("d", 250)+"/\n") || !strings.Contains(result, "\n(could not read 1 path(s): deep/") {
+		t.Fatalf("expected the listing and a note on the unreadable directory, got %q and %v", result, err)
+	}
+}
+
+func TestExecuteToolCalls(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	order := []string{}
+	track := func(name string, input json.RawMessage) (string, error) {
+		call := struct{ N int }{}
+		json.Unmarshal(input, &call)
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		order = append(order, fmt.Sprintf("%s%d", name, call.N))
+		mu.Unlock()
+		return fmt.Sprintf("%s%d", name, call.N), nil
+	}
+	tools := []ToolDefinition{
+		{Name: "read", Function: func(ctx context.Context, input json.RawMessage) (string, error) { return track("read", input) }},
+		{Name: "write", Function: func(ctx context.Context, input json.RawMessage) (string, error) { return track("write", input) }, Mutating: true},
+	}
+	permissions := &Permissions{}
+	permissions.AddSessionRule(PermissionRule{Tool: "write", Action: permissionAllow})
+	agent := NewAgent(nil, nil, tools, DefaultConfig(), nil, permissions)
+
+	message := anthropic.Message{}
+	err := json.Unmarshal([]byte(`{"role": "assistant", "content": [
+		{"type": "text", "text": "reading"},
+		{"type": "tool_use", "id": "t1", "name": "read", "input": {"n": 1}},
+		{"type": "tool_use", "id": "t2", "name": "read", "input": {"n": 2}},
+		{"type": "tool_use", "id": "t3", "name": "read", "input": {"n": 3}},
+		{"type": "tool_use", "id": "t4", "name": "write", "input": {"n": 4}},
+		{"type": "tool_use", "id": "t5", "name": "missing", "input": {"n": 5}},
+		{"type": "tool_use", "id": "t6", "name": "read", "input": {"n": 6}}
+	]}`), &message)
+	if err != nil {
+		t.Fatalf("failed to build message: %v", err)
+	}
+
+	// happy path: the results come back in the order of the calls
+	start := time.Now()
+	results := agent.executeToolCalls(context.Background(), &message)
+	elapsed := time.Since(start)
+	expected := []string{"read1", "read2", "read3", "write4", "tool not found", "read6"}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if result.OfToolResult == nil || result.OfToolResult.ToolUseID != fmt.Sprintf("t%d", i+1) {
+			t.Fatalf("expected result %d to be for t%d, got %+v", i, i+1, result)
+		}
+		if text := result.OfToolResult.Content[0].OfText.Text; text != expected[i] {
+			t.Fatalf("expected %q, got %q", expected[i], text)
+		}
+	}
+
+	// test the reads ran together but never alongside the write
+	if maxRunning != 3 {
+		t.Fatalf("expected 3 calls to run at once, got %d", maxRunning)
+	}
+	if order[3] != "write4" || order[4] != "read6" {
+		t.Fatalf("expected the write to run after the reads before it and before the ones after it, got %v", order)
+	}
+	if elapsed >= 250*time.Millisecond {
+		t.Fatalf("expected the reads to run concurrently, took %s", elapsed)
+	}
+}
+
+func TestExecuteToolTimeout(t *testing.T) {
+	wait := func(ctx context.Context, input json.RawMessage) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second):
+			return "done", nil
+		}
+	}
+	tools := []ToolDefinition{{Name: "wait", Function: wait, Timeout: 10 * time.Millisecond}}
+	agent := NewAgent(nil, nil, tools, DefaultConfig(), nil, nil)
+
+	// happy path: a call running past the tool's timeout is cancelled
+	result := agent.executeTool(context.Background(), "t1", "wait", json.RawMessage(`{}`))
+	if !result.OfToolResult.IsError.Value || result.OfToolResult.Content[0].OfText.Text != "tool call timed out after 10ms" {
+		t.Fatalf("expected a timeout, got %+v", result.OfToolResult)
+	}
+
+	// test a call the user interrupts
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	agent.tools[0].Timeout = time.Minute
+	result = agent.executeTool(ctx, "t2", "wait", json.RawMessage(`{}`))
+	if result.OfToolResult.Content[0].OfText.Text != "tool call was interrupted by the user" {
+		t.Fatalf("expected an interruption, got %+v", result.OfToolResult)
+	}
+
+	// test calls after an interruption don't run
+	result = agent.executeTool(ctx, "t3", "wait", json.RawMessage(`{}`))
+	if result.OfToolResult.Content[0].OfText.Text != "tool call was interrupted by the user before it ran" {
+		t.Fatalf("expected the call to be skipped, got %+v", result.OfToolResult)
+	}
+}
+
+func TestExecuteToolInvalidInput(t *testing.T) {
+	agent := NewAgent(nil, nil, []ToolDefinition{ReadFileDefinition}, DefaultConfig(), nil, nil)
+
+	// happy path: arguments that aren't a JSON object are refused without calling the tool
+	result := agent.executeTool(context.Background(), "t1", "read_file", json.RawMessage(`"{\"path\": \"main.go"`))
+	if !result.OfToolResult.IsError.Value || !strings.HasPrefix(result.OfToolResult.Content[0].OfText.Text, "invalid tool input") {
+		t.Fatalf("expected an invalid input error, got %+v", result.OfToolResult)
+	}
+
+	// test the tool itself returns an error rather than panicking
+	_, err := ReadFile(context.Background(), json.RawMessage(`"main.go"`))
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+}
+
+// testProvider answers every request with the same response.
+type testProvider struct {
+	response string
+	requests int
+}
+
+func (p *testProvider) Stream(ctx context.Context, request InferenceRequest, onEvent func(StreamEvent)) (*anthropic.Message, error) {
+	p.requests++
+	message := anthropic.Message{}
+	err := json.Unmarshal([]byte(p.response), &message)
+	return &message, err
+}
+
+func TestRunGivesUpOnTruncatedResponses(t *testing.T) {
+	calls := 0
+	tools := []ToolDefinition{{Name: "read", Function: func(ctx context.Context, input json.RawMessage) (string, error) {
+		calls++
+		return "read", nil
+	}}}
+	provider := &testProvider{response: `{"role": "assistant", "stop_reason": "max_tokens", "content": [
+		{"type": "tool_use", "id": "complete", "name": "read", "input": {}},
+		{"type": "tool_use", "id": "truncated", "name": "read", "input": {}}
+	]}`}
+	prompts := []string{"hi"}
+	getUserMessage := func() (string, bool) {
+		if len(prompts) == 0 {
+			return "", false
+		}
+		prompt := prompts[0]
+		prompts = prompts[1:]
+		return prompt, true
+	}
+	agent := NewAgent(provider, getUserMessage, tools, DefaultConfig(), nil, nil)
+
+	err := agent.Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if provider.requests != maxContinuations+1 || calls != maxContinuations {
+		t.Fatalf("expected %d requests and %d calls, got %d and %d", maxContinuations+1, maxContinuations, provider.requests, calls)
+	}
+
+	// happy path: the last response's tool calls get results and the turn ends with a reply
+	checkClosedHistory(t, agent.conversation)
+
+	// test responses with nothing but a cut off tool call still leave a valid history
+	provider = &testProvider{response: `{"role": "assistant", "stop_reason": "max_tokens", "content": [
+		{"type": "tool_use", "id": "truncated", "name": "read", "input": {}}
+	]}`}
+	prompts = []string{"hi"}
+	agent = NewAgent(provider, getUserMessage, tools, DefaultConfig(), nil, nil)
+	err = agent.Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if provider.requests != maxContinuations+1 {
+		t.Fatalf("expected %d requests, got %d", maxContinuations+1, provider.requests)
+	}
+	checkClosedHistory(t, agent.conversation)
+}
+
+// checkClosedHistory fails the test unless the conversation alternates between
+// the user and Claude, answers every tool call and ends with Claude's reply.
+func checkClosedHistory(t *testing.T, conversation []anthropic.MessageParam) {
+	t.Helper()
+	last := conversation[len(conversation)-1]
+	if last.Role != anthropic.MessageParamRoleAssistant || last.Content[0].OfText == nil {
+		t.Fatalf("expected a stand-in reply, got %+v", last)
+	}
+	for i, message := range conversation {
+		if i > 0 && message.Role == conversation[i-1].Role {
+			t.Fatalf("expected the roles to alternate, got two %s messages at %d", message.Role, i)
+		}
+		if message.Role != anthropic.MessageParamRoleAssistant {
+			continue
+		}
+		for _, block := range message.Content {
+			if block.OfToolUse == nil {
+				continue
+			}
+			next := conversation[i+1]
+			if next.Content[0].OfToolResult == nil || next.Content[0].OfToolResult.ToolUseID != block.OfToolUse.ID {
+				t.Fatalf("expected a result for tool call %s, got %+v", block.OfToolUse.ID, next)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// TrustStore remembers which project files the user has approved, by the hash
+// of their content. Files in a project may come with the repository, so the
+// ones that let the agent act without asking are only used once approved, and
+// approved again whenever they change.
+type TrustStore struct {
+	// path is where the approvals are kept; an empty path remembers none
+	path string
+}
+
+func NewTrustStore(path string) *TrustStore {
+	return &TrustStore{path: path}
+}
+
+// UserTrustStore returns the trust store in the user's config directory, out
+// of reach of any project.
+func UserTrustStore() *TrustStore {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return NewTrustStore("")
+	}
+	return NewTrustStore(filepath.Join(dir, "agent", "trusted.json"))
+}
+
+// Trusted reports whether the user approved the file at path with this content.
+func (s *TrustStore) Trusted(path string, content []byte) bool {
+	hashes := s.load()
+	hash, ok := hashes[trustKey(path)]
+	return ok && hash == trustHash(content)
+}
+
+// Trust records that the user approved the file at path with this content.
+func (s *TrustStore) Trust(path string, content []byte) error {
+	if s.path == "" {
+		return nil
+	}
+	hashes := s.load()
+	hashes[trustKey(path)] = trustHash(content)
+	data, err := json.MarshalIndent(hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(data, '\n'), 0600)
+}
+
+// load reads the approvals, keyed by absolute path. A missing or broken store
+// means nothing is approved.
+func (s *TrustStore) load() map[string]string {
+	hashes := map[string]string{}
+	if s.path == "" {
+		return hashes
+	}
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return hashes
+	}
+	json.Unmarshal(content, &hashes)
+	return hashes
+}
+
+func trustKey(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+func trustHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
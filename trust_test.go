@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewTrustStore(filepath.Join(dir, "agent", "trusted.json"))
+	path := filepath.Join(dir, "config.json")
+
+	// test nothing is trusted before it is approved
+	if store.Trusted(path, []byte("{}")) {
+		t.Fatalf("expected the file not to be trusted yet")
+	}
+
+	// happy path: an approved file is trusted with the same content, across stores
+	err := store.Trust(path, []byte("{}"))
+	if err != nil {
+		t.Fatalf("failed to trust the file: %v", err)
+	}
+	if !NewTrustStore(store.path).Trusted(path, []byte("{}")) {
+		t.Fatalf("expected the approval to be saved")
+	}
+
+	// test a changed file or another file with the same content isn't trusted
+	if store.Trusted(path, []byte(`{"post_edit_hooks": []}`)) || store.Trusted(filepath.Join(dir, "other.json"), []byte("{}")) {
+		t.Fatalf("expected the approval to cover only the file as it was")
+	}
+
+	// test a store without a path remembers nothing
+	store = NewTrustStore("")
+	if store.Trust(path, []byte("{}")) != nil || store.Trusted(path, []byte("{}")) {
+		t.Fatalf("expected an empty store to trust nothing")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Workspace confines the file tools to a root directory, plus any extra
+// directories the user opted into. Every path a tool touches goes through
+// Resolve, which follows symlinks so a link can't be used to escape the root,
+// and every write goes through WriteFile or RemoveFile so it can be undone.
+type Workspace struct {
+	root        string
+	allowedDirs []string
+	checkpoints *Checkpoints
+	// written is the files written since the last TakeWritten, in order
+	written []string
+}
+
+// workspace is the workspace used by all tools. It defaults to the working
+// directory and is replaced in main from the configuration.
+var workspace = mustNewWorkspace(".")
+
+func NewWorkspace(root string, allowedDirs []string) (*Workspace, error) {
+	resolvedRoot, err := resolveDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace root: %w", err)
+	}
+
+	w := &Workspace{root: resolvedRoot, allowedDirs: []string{}, checkpoints: NewCheckpoints()}
+	for _, dir := range allowedDirs {
+		resolvedDir, err := resolveDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed directory: %w", err)
+		}
+		w.allowedDirs = append(w.allowedDirs, resolvedDir)
+	}
+	return w, nil
+}
+
+func mustNewWorkspace(root string) *Workspace {
+	w, err := NewWorkspace(root, nil)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// Root returns the absolute workspace root.
+func (w *Workspace) Root() string {
+	return w.root
+}
+
+// Resolve turns a path given to a tool into an absolute path, resolving relative
+// paths against the workspace root. It returns an error if the path, after
+// following symlinks, is outside the root and the allowed directories. Paths
+// that don't exist yet are checked through their closest existing parent.
+func (w *Workspace) Resolve(p string) (string, error) {
+	if p == "" {
+		p = "."
+	}
+	abs := p
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(w.root, abs)
+	}
+	abs = filepath.Clean(abs)
+
+	resolved, err := resolveExisting(abs)
+	if err != nil {
+		return "", err
+	}
+
+	if isWithin(w.root, resolved) {
+		return resolved, nil
+	}
+	for _, dir := range w.allowedDirs {
+		if isWithin(dir, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("access denied: %s is outside the workspace root %s", p, w.root)
+}
+
+// Checkpoints returns the file snapshots taken before each write, grouped by user turn.
+func (w *Workspace) Checkpoints() *Checkpoints {
+	return w.checkpoints
+}
+
+// WriteFile writes content to an already resolved path, snapshotting the file first.
+func (w *Workspace) WriteFile(path string, content []byte) error {
+	err := w.checkpoints.Snapshot(path)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", path, err)
+	}
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(w.written, path) {
+		w.written = append(w.written, path)
+	}
+	return nil
+}
+
+// MkdirAll creates an already resolved directory along with any missing
+// parents, recording the ones it creates in the checkpoint so that undoing the
+// turn removes them.
+func (w *Workspace) MkdirAll(dir string) error {
+	missing := []string{}
+	for d := dir; ; d = filepath.Dir(d) {
+		_, err := os.Stat(d)
+		if !os.IsNotExist(err) || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		w.checkpoints.CreatedDir(missing[i])
+	}
+	return nil
+}
+
+// TakeWritten returns the files written since it was last called and forgets them.
+func (w *Workspace) TakeWritten() []string {
+	written := w.written
+	w.written = nil
+	return written
+}
+
+// RemoveFile deletes an already resolved path, snapshotting the file first.
+func (w *Workspace) RemoveFile(path string) error {
+	err := w.checkpoints.Snapshot(path)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+// Rel returns the path relative to the workspace root if it is inside it, and the absolute path otherwise.
+func (w *Workspace) Rel(abs string) string {
+	if isWithin(w.root, abs) {
+		rel, err := filepath.Rel(w.root, abs)
+		if err == nil {
+			return rel
+		}
+	}
+	return abs
+}
+
+func resolveDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return resolved, nil
+}
+
+// resolveExisting follows the symlinks in the longest existing prefix of an
+// absolute path and appends the rest of the path unchanged. A dangling
+// symlink is followed to where its target would be, since writing through it
+// would create the target.
+func resolveExisting(abs string) (string, error) {
+	missing := []string{}
+	current := abs
+	links := 0
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		info, err := os.Lstat(current)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			links++
+			if links > 255 {
+				return "", fmt.Errorf("too many levels of symbolic links in %s", abs)
+			}
+			target, err := os.Readlink(current)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				// the link exists, so its directory does too
+				dir, err := filepath.EvalSymlinks(filepath.Dir(current))
+				if err != nil {
+					return "", err
+				}
+				target = filepath.Join(dir, target)
+			}
+			current = filepath.Clean(target)
+			continue
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return abs, nil
+		}
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
+
+func isWithin(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
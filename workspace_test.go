@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkspaceResolve(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(root, "inside.txt"), []byte("inside"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	os.Symlink(outside, filepath.Join(root, "link"))
+	os.Symlink(filepath.Join(outside, "pwned.txt"), filepath.Join(root, "dangling"))
+	os.Symlink(filepath.Join(outside, "missing", "dir"), filepath.Join(root, "dangling_dir"))
+	os.Symlink("created.txt", filepath.Join(root, "dangling_inside"))
+
+	w, err := NewWorkspace(root, nil)
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+
+	// happy path: relative paths resolve against the root, including ones that don't exist yet
+	path, err := w.Resolve("inside.txt")
+	if err != nil {
+		t.Fatalf("failed to resolve path: %v", err)
+	}
+	if path != filepath.Join(w.Root(), "inside.txt") {
+		t.Fatalf("expected %s, got %s", filepath.Join(w.Root(), "inside.txt"), path)
+	}
+	_, err = w.Resolve("new/dir/file.txt")
+	if err != nil {
+		t.Fatalf("failed to resolve new path: %v", err)
+	}
+	path, err = w.Resolve("dangling_inside")
+	if err != nil || path != filepath.Join(w.Root(), "created.txt") {
+		t.Fatalf("expected a dangling link to resolve to its target in the root, got %s and %v", path, err)
+	}
+
+	// test paths escaping the root
+	for _, p := range []string{"../../etc/passwd", "/etc/passwd", "link/secret.txt", "new/../../x", "dangling", "dangling_dir/x.txt"} {
+		_, err = w.Resolve(p)
+		if err == nil {
+			t.Fatalf("expected error for %s, got nil", p)
+		}
+	}
+
+	// test allowed directories
+	w, err = NewWorkspace(root, []string{outside})
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	_, err = w.Resolve("link/secret.txt")
+	if err != nil {
+		t.Fatalf("failed to resolve path in allowed directory: %v", err)
+	}
+}
+
+func TestToolsStayInWorkspace(t *testing.T) {
+	readFileInput := json.RawMessage(`{"path": "../../etc/passwd"}`)
+	_, err := ReadFile(context.Background(), readFileInput)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	editFileInput := json.RawMessage(`{"path": "/tmp/escaped.txt", "old_str": "", "new_str": "test"}`)
+	_, err = EditFile(context.Background(), editFileInput)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	// test a dangling symlink can't be used to create a file outside the workspace
+	outside := filepath.Join(t.TempDir(), "pwned.txt")
+	link := filepath.Join(workspace.Root(), "dangling_test_link")
+	os.Symlink(outside, link)
+	defer os.Remove(link)
+	_, err = EditFile(context.Background(), json.RawMessage(`{"path": "dangling_test_link", "old_str": "", "new_str": "test"}`))
+	if _, statErr := os.Stat(outside); err == nil || statErr == nil {
+		t.Fatalf("expected the edit to be refused, got %v", err)
+	}
+
+	listFilesInput := json.RawMessage(`{"path": ".."}`)
+	_, err = ListFiles(context.Background(), listFilesInput)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+}